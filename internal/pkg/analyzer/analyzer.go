@@ -2,6 +2,7 @@ package analyzer
 
 import (
 	"fmt"
+	. "github.com/hotid/streamsurfer/internal/pkg/logging"
 	"github.com/hotid/streamsurfer/internal/pkg/stats"
 	. "github.com/hotid/streamsurfer/internal/pkg/structures"
 	"time"
@@ -28,7 +29,7 @@ func ProblemAnalyzer(cfg *Config) {
 				case HLS:
 					reports = analyzeHLS(streamKey, hist, &checkPoint)
 				case HDS:
-					reports = analyzeHDS(hist)
+					reports = analyzeHDS(streamKey, hist, &checkPoint)
 				case HTTP:
 					reports = analyzeHTTP(hist)
 				}
@@ -62,6 +63,38 @@ func LoadReports() []Report {
 // the any single check. Then we aggregate problem tasks into error ranges ([]ErrRange). Report builder then
 // analyze error ranges and make reports on them.
 func analyzeHLS(key Key, hist []KeepedResult, lastCheck *CheckPoint) (reports []Report) {
+	errorRanges, isRangeOpened := findErrorRanges(key, hist, lastCheck)
+	// Permanent errors report. Error is permanent if it continued more than 10 minute.
+	for _, val := range errorRanges {
+		if val.Discontinued.Sub(val.Occured) > 10*time.Minute {
+			reports = append(reports, generatePermanentErrorsReport(key, errorRanges, isRangeOpened))
+		}
+	}
+	return reports
+}
+
+// Analyze HDS in the same way as HLS. Additionally report about broken manifests,
+// bootstrap info and fragments because such errors usually mean packager problems.
+func analyzeHDS(key Key, hist []KeepedResult, lastCheck *CheckPoint) (reports []Report) {
+	var permanent bool
+
+	errorRanges, isRangeOpened := findErrorRanges(key, hist, lastCheck)
+	for _, val := range errorRanges {
+		switch {
+		case val.Err == BADMANIFEST, val.Err == BADBOOTSTRAP, val.Err == BADFRAGMENT:
+			reports = append(reports, generateFormatErrorsReport(key, val))
+		case val.Discontinued.Sub(val.Occured) > 10*time.Minute:
+			permanent = true
+		}
+	}
+	if permanent { // single report covers all ranges
+		reports = append(reports, generatePermanentErrorsReport(key, errorRanges, isRangeOpened))
+	}
+	return reports
+}
+
+// Aggregate failed tasks into error ranges. Returns found ranges and flag of the range not closed yet.
+func findErrorRanges(key Key, hist []KeepedResult, lastCheck *CheckPoint) ([]ErrRange, bool) {
 	var (
 		isRangeOpened           bool       // problem under analyzator cursor
 		isTaskOK                = true     // statuses for current check and task
@@ -111,24 +144,13 @@ func analyzeHLS(key Key, hist []KeepedResult, lastCheck *CheckPoint) (reports []
 		}
 	}
 
-	lastCheck = &CheckPoint{toTid, stop, nil}
 	if isRangeOpened && errlevel > 0 { // период остался незакрыт
 		errorRanges = append(errorRanges, ErrRange{fromTid, toTid, start, stop, errlevel})
 	}
 	if len(errorRanges) > 0 {
 		fmt.Printf("err range for %s %#v\n", key.String(), errorRanges)
 	}
-	// Permanent errors report. Error is permanent if it continued more than 10 minute.
-	for _, val := range errorRanges {
-		if val.Discontinued.Sub(val.Occured) > 10*time.Minute {
-			reports = append(reports, generatePermanentErrorsReport(key, errorRanges, isRangeOpened))
-		}
-	}
-	return reports
-}
-
-func analyzeHDS(hist []KeepedResult) []Report {
-	return []Report{}
+	return errorRanges, isRangeOpened
 }
 
 func analyzeHTTP(hist []KeepedResult) []Report {
@@ -143,3 +165,8 @@ func analyzeHTTP(hist []KeepedResult) []Report {
 func generatePermanentErrorsReport(key Key, ranges []ErrRange, errorPersists bool) Report {
 	return Report{Title: "Sample report"}
 }
+
+// Stream format errors report generator
+func generateFormatErrorsReport(key Key, errRange ErrRange) Report {
+	return Report{Error: errRange.Err, Severity: CRITICAL, Title: StreamErr2String(errRange.Err), Generated: time.Now()}
+}
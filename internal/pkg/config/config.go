@@ -9,7 +9,10 @@ import (
 	. "github.com/hotid/streamsurfer/internal/pkg/structures"
 	"io/ioutil"
 	"launchpad.net/goyaml"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -41,24 +44,83 @@ type configYAML struct {
 
 // rawconfig group data
 type configGroupYAML struct {
-	Type                   string        `yaml:"type,omitempty"`
-	URI                    string        `yaml:"streams-uri,omitempty"`               // external link list
-	Streams                []string      `yaml:"streams,omitempty"`                   // link list
-	Probers                int           `yaml:"probers,omitempty"`                   // num of
-	MediaProbers           int           `yaml:"media-probers,omitempty"`             // num of
-	CheckBrokenTime        int           `yaml:"check-broken-time"`                   // ms
-	ConnectTimeout         time.Duration `yaml:"connect-timeout,omitempty"`           // sec
-	RWTimeout              time.Duration `yaml:"rw-timeout,omitempty"`                // sec
-	SlowWarningTimeout     time.Duration `yaml:"slow-warning-timeout,omitempty"`      // sec
-	VerySlowWarningTimeout time.Duration `yaml:"very-slow-warning-timeout,omitempty"` // sec
-	TimeBetweenTasks       time.Duration `yaml:"time-between-tasks,omitempty"`        // sec
-	TaskTTL                time.Duration `yaml:"task-ttl,omitempty"`                  // sec
-	TryOneSegment          bool          `yaml:"one-segment,omitempty"`
-	MethodHTTP             string        `yaml:"http-method,omitempty"` // GET, HEAD
-	ErrorLog               string        `yaml:"error-log,omitempty"`
-	ParseMethod            string        `yaml:"parse-method,omitempty"` // regexp for alternative method of title/name parsing from the URL
-	User                   string        `yaml:"user,omitempty"`
-	Pass                   string        `yaml:"pass,omitempty"`
+	Type                   string            `yaml:"type,omitempty"`
+	URI                    string            `yaml:"streams-uri,omitempty"`               // external link list
+	Streams                []string          `yaml:"streams,omitempty"`                   // link list
+	Probers                int               `yaml:"probers,omitempty"`                   // num of
+	MediaProbers           int               `yaml:"media-probers,omitempty"`             // num of
+	CheckBrokenTime        int               `yaml:"check-broken-time"`                   // ms
+	ConnectTimeout         time.Duration     `yaml:"connect-timeout,omitempty"`           // sec
+	RWTimeout              time.Duration     `yaml:"rw-timeout,omitempty"`                // sec
+	SlowWarningTimeout     time.Duration     `yaml:"slow-warning-timeout,omitempty"`      // sec
+	VerySlowWarningTimeout time.Duration     `yaml:"very-slow-warning-timeout,omitempty"` // sec
+	SlowPhase              string            `yaml:"slow-phase,omitempty"`                // total, dns, connect, tls, ttfb, transfer
+	CertWarning            int               `yaml:"cert-warning,omitempty"`              // days
+	TimeBetweenTasks       time.Duration     `yaml:"time-between-tasks,omitempty"`        // sec
+	TaskTTL                time.Duration     `yaml:"task-ttl,omitempty"`                  // sec
+	TryOneSegment          bool              `yaml:"one-segment,omitempty"`
+	StaleFactor            float64           `yaml:"stale-factor,omitempty"`    // in target durations
+	LatencyWarning         time.Duration     `yaml:"latency-warning,omitempty"` // sec
+	LatencyError           time.Duration     `yaml:"latency-error,omitempty"`   // sec
+	LowLatency             bool              `yaml:"low-latency,omitempty"`
+	MethodHTTP             string            `yaml:"http-method,omitempty"` // GET, HEAD
+	LimitHTTP              int64             `yaml:"http-limit,omitempty"`  // bytes
+	EdgeProbe              bool              `yaml:"edge-probe,omitempty"`  // probe each address of the stream host
+	IPFamily               string            `yaml:"ip-family,omitempty"`   // v4, v6, both
+	Proxy                  string            `yaml:"proxy,omitempty"`       // http://, https:// or socks5:// URL
+	SourceAddr             string            `yaml:"source-address,omitempty"`
+	Route                  string            `yaml:"route,omitempty"`     // tag of results
+	ConnPool               string            `yaml:"conn-pool,omitempty"` // cold, warm, both
+	Headers                map[string]string `yaml:"headers,omitempty"`   // name: template
+	CookieJar              bool              `yaml:"cookie-jar,omitempty"`
+	Sign                   ConfigSign        `yaml:"sign,omitempty"`
+	ErrorLog               string            `yaml:"error-log,omitempty"`
+	ParseMethod            string            `yaml:"parse-method,omitempty"` // regexp for alternative method of title/name parsing from the URL
+	User                   string            `yaml:"user,omitempty"`
+	Pass                   string            `yaml:"pass,omitempty"`
+}
+
+// Parse proxy and source address of the group. Bad values reported and ignored so checks go directly.
+func parseRouteConfig(groupName string, groupData configGroupYAML, group *ConfigGroup) {
+	if groupData.Proxy != "" {
+		proxy, err := url.Parse(groupData.Proxy)
+		switch {
+		case err != nil, proxy.Host == "", proxy.Scheme != "http" && proxy.Scheme != "https" && proxy.Scheme != "socks5":
+			fmt.Printf("Bad proxy %s for group %s, direct connections used.\n", groupData.Proxy, groupName)
+		default:
+			group.Proxy = proxy
+		}
+	}
+	if group.Proxy != nil && group.IPFamily != "" { // the family would select the connection to the proxy, not to the stream host
+		fmt.Printf("Option ip-family ignored for group %s checked through the proxy.\n", groupName)
+		group.IPFamily = ""
+	}
+	if groupData.SourceAddr != "" {
+		if group.SourceAddr = net.ParseIP(groupData.SourceAddr); group.SourceAddr == nil {
+			fmt.Printf("Bad source address %s for group %s, any address used.\n", groupData.SourceAddr, groupName)
+		}
+	}
+	switch {
+	case groupData.Route != "":
+		group.RouteTag = groupData.Route
+	case group.Proxy != nil:
+		group.RouteTag = group.Proxy.Host
+	case group.SourceAddr != nil:
+		group.RouteTag = group.SourceAddr.String()
+	}
+}
+
+// Parse header templates, cookie jar and URL signing of the group.
+func parseRequestConfig(groupData configGroupYAML, group *ConfigGroup) {
+	group.Headers = groupData.Headers
+	if groupData.CookieJar {
+		group.Jar, _ = cookiejar.New(nil) // never fails without options
+	}
+	group.Sign = groupData.Sign
+	group.Sign.Scheme = strings.ToLower(group.Sign.Scheme)
+	for i, level := range group.Sign.Apply {
+		group.Sign.Apply[i] = strings.ToLower(level)
+	}
 }
 
 // Read raw config with YAML validation
@@ -114,13 +176,26 @@ func parseGroupsConfig(rawconfig *configYAML, config *Config) {
 			RWTimeout:              groupData.RWTimeout,
 			SlowWarningTimeout:     groupData.SlowWarningTimeout,
 			VerySlowWarningTimeout: groupData.VerySlowWarningTimeout,
+			SlowPhase:              strings.ToLower(groupData.SlowPhase),
+			CertWarning:            groupData.CertWarning,
 			TaskTTL:                groupData.TaskTTL,
 			TryOneSegment:          groupData.TryOneSegment,
+			StaleFactor:            groupData.StaleFactor,
+			LatencyWarning:         groupData.LatencyWarning,
+			LatencyError:           groupData.LatencyError,
+			LowLatency:             groupData.LowLatency,
 			MethodHTTP:             strings.ToUpper(groupData.MethodHTTP),
+			LimitHTTP:              groupData.LimitHTTP,
+			EdgeProbe:              groupData.EdgeProbe,
+			IPFamily:               strings.ToLower(groupData.IPFamily),
+			ConnPool:               strings.ToLower(groupData.ConnPool),
 			User:                   groupData.User,
 			Pass:                   groupData.Pass,
 		}
 
+		parseRouteConfig(groupName, groupData, config.GroupParams[key])
+		parseRequestConfig(groupData, config.GroupParams[key])
+
 		if groupData.URI != "" {
 			config.GroupStreams[key] = make(map[Key]Stream)
 			addRemoteConfig(config.GroupStreams[key], config.GroupParams[key], groupName, groupData.URI, groupData.User, groupData.Pass)
@@ -189,6 +264,18 @@ func String2StreamType(s string) StreamType {
 		return HDS
 	case "wv":
 		return WV
+	case "dash":
+		return DASH
+	case "mss":
+		return MSS
+	case "mp4":
+		return MP4
+	case "icy":
+		return ICY
+	case "rtsp":
+		return RTSP
+	case "rtmp":
+		return RTMP
 	case "http":
 		return HTTP
 	default:
@@ -199,10 +286,10 @@ func String2StreamType(s string) StreamType {
 // Helper. Split stream link to URI and Name parts.
 // Supported both cases: title<space>uri and uri<space>title
 // If `re` presents then name parsed from uri by regular expression.
-// URI must be prepended by http:// or https://
+// URI must be prepended by http(s)://, rtsp(s):// or rtmp(s)://
 func splitName(re, source string) (uri, name, title string) {
 	source = strings.TrimSpace(source)
-	sep := regexp.MustCompile("htt(p|ps)://")
+	sep := regexp.MustCompile("(htt(p|ps)|rtsps?|rtmps?)://")
 	loc := sep.FindStringIndex(source)
 	if loc != nil {
 		if loc[0] == 0 { // uri title
@@ -0,0 +1,178 @@
+package helpers
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Open the connection to `addr` (host:port) tunneled through the proxy: HTTP CONNECT for http://
+// and https:// proxies, CONNECT command for socks5:// proxies. The host resolved by the proxy.
+// Used for raw protocols not supported by net/http (ICY, RTSP, RTMP).
+func ProxyDial(dialer *net.Dialer, proxy *url.URL, addr string) (net.Conn, error) {
+	port := proxy.Port()
+	if port == "" {
+		switch proxy.Scheme {
+		case "https":
+			port = "443"
+		case "socks5":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort(proxy.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	if dialer.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(dialer.Timeout))
+	}
+	switch proxy.Scheme {
+	case "socks5":
+		err = socks5Connect(conn, proxy.User, addr)
+	case "https":
+		tlsconn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+		if err = tlsconn.Handshake(); err == nil {
+			conn, err = httpConnect(tlsconn, proxy.User, addr)
+		}
+	default:
+		conn, err = httpConnect(conn, proxy.User, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// Connection with the data read ahead by the reader of the proxy response.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// Helper. Ask HTTP proxy to open the tunnel to `addr`.
+func httpConnect(conn net.Conn, user *url.Userinfo, addr string) (net.Conn, error) {
+	req := &http.Request{Method: "CONNECT", URL: &url.URL{Opaque: addr}, Host: addr, Header: make(http.Header)}
+	if user != nil {
+		pass, _ := user.Password()
+		req.SetBasicAuth(user.Username(), pass)
+		req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+		req.Header.Del("Authorization")
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return conn, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("proxy CONNECT to %s: %s", addr, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+var errSocks5 = errors.New("socks5 proxy rejected the connection")
+
+// Helper. SOCKS5 handshake (RFC 1928) with username/password authentication (RFC 1929)
+// when credentials set in the proxy URL.
+func socks5Connect(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return err
+	}
+	methods := []byte{0x00} // no authentication
+	if user != nil {
+		methods = append(methods, 0x02) // username/password
+	}
+	if _, err = conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	switch {
+	case reply[0] != 0x05:
+		return errSocks5
+	case reply[1] == 0x02 && user != nil:
+		pass, _ := user.Password()
+		if len(user.Username()) > 255 || len(pass) > 255 {
+			return errSocks5
+		}
+		auth := append([]byte{0x01, byte(len(user.Username()))}, user.Username()...)
+		auth = append(append(auth, byte(len(pass))), pass...)
+		if _, err = conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errSocks5
+		}
+	case reply[1] != 0x00:
+		return errSocks5
+	}
+	req := []byte{0x05, 0x01, 0x00} // CONNECT
+	switch ip := net.ParseIP(host); {
+	case ip != nil && ip.To4() != nil:
+		req = append(append(req, 0x01), ip.To4()...)
+	case ip != nil:
+		req = append(append(req, 0x04), ip.To16()...)
+	case len(host) > 255:
+		return errSocks5
+	default:
+		req = append(append(req, 0x03, byte(len(host))), host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+	head := make([]byte, 4)
+	if _, err = io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[0] != 0x05 || head[1] != 0x00 {
+		return fmt.Errorf("%w (code %d)", errSocks5, head[1])
+	}
+	var skip int // bound address and port of the reply
+	switch head[3] {
+	case 0x01:
+		skip = net.IPv4len + 2
+	case 0x04:
+		skip = net.IPv6len + 2
+	case 0x03:
+		if _, err = io.ReadFull(conn, head[:1]); err != nil {
+			return err
+		}
+		skip = int(head[0]) + 2
+	default:
+		return errSocks5
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
@@ -0,0 +1,138 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Helper. Serve the single connection of the test proxy: `handshake` answers the proxy
+// request then the tunnel echoes data back.
+func testProxy(t *testing.T, handshake func(conn net.Conn, br *bufio.Reader) bool) *url.URL {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		if handshake(conn, br) {
+			io.Copy(conn, br)
+		}
+	}()
+	return &url.URL{Host: ln.Addr().String()}
+}
+
+// Helper. Send data through the tunnel and read it back.
+func testEcho(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("tunnel returned %q, %v", buf, err)
+	}
+}
+
+func TestProxyDialHTTP(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   *url.Userinfo
+		status int
+		err    bool
+	}{
+		{"connect", nil, http.StatusOK, false},
+		{"connect with credentials", url.UserPassword("user", "secret"), http.StatusOK, false},
+		{"rejected", nil, http.StatusForbidden, true},
+	}
+	for _, test := range tests {
+		test := test // used by the proxy goroutine
+		proxy := testProxy(t, func(conn net.Conn, br *bufio.Reader) bool {
+			req, err := http.ReadRequest(br)
+			if err != nil || req.Method != "CONNECT" || req.Host != "stream.example.com:554" {
+				t.Errorf("%s: bad proxy request %v, %v", test.name, req, err)
+				return false
+			}
+			auth := &http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}
+			if user, pass, ok := auth.BasicAuth(); ok != (test.user != nil) || ok && (user != "user" || pass != "secret") {
+				t.Errorf("%s: bad credentials %q %q", test.name, user, pass)
+			}
+			fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", test.status, http.StatusText(test.status))
+			return test.status == http.StatusOK
+		})
+		proxy.Scheme = "http"
+		proxy.User = test.user
+		conn, err := ProxyDial(&net.Dialer{Timeout: time.Second}, proxy, "stream.example.com:554")
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if err == nil {
+			testEcho(t, conn)
+		}
+	}
+}
+
+func TestProxyDialSocks5(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    *url.Userinfo
+		addr    string
+		request []byte // expected CONNECT request
+		reply   byte
+		err     bool
+	}{
+		{"host name", nil, "stream.example.com:1935", append(append([]byte{5, 1, 0, 3, 18}, "stream.example.com"...), 0x07, 0x8f), 0, false},
+		{"IPv4 address", url.UserPassword("user", "secret"), "192.0.2.1:554", []byte{5, 1, 0, 1, 192, 0, 2, 1, 0x02, 0x2a}, 0, false},
+		{"IPv6 address", nil, "[2001:db8::1]:80", []byte{5, 1, 0, 4, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 80}, 0, false},
+		{"rejected", nil, "stream.example.com:1935", append(append([]byte{5, 1, 0, 3, 18}, "stream.example.com"...), 0x07, 0x8f), 5, true},
+	}
+	for _, test := range tests {
+		test := test // used by the proxy goroutine
+		proxy := testProxy(t, func(conn net.Conn, br *bufio.Reader) bool {
+			greeting := make([]byte, 2)
+			io.ReadFull(br, greeting)
+			methods := make([]byte, greeting[1])
+			io.ReadFull(br, methods)
+			if test.user != nil {
+				conn.Write([]byte{5, 2})
+				auth := make([]byte, 2+len("user")+1+len("secret"))
+				io.ReadFull(br, auth)
+				if !bytes.Equal(auth, []byte("\x01\x04user\x06secret")) {
+					t.Errorf("%s: bad credentials %q", test.name, auth)
+				}
+				conn.Write([]byte{1, 0})
+			} else {
+				conn.Write([]byte{5, 0})
+			}
+			request := make([]byte, len(test.request))
+			io.ReadFull(br, request)
+			if !bytes.Equal(request, test.request) {
+				t.Errorf("%s: got request %v", test.name, request)
+			}
+			conn.Write([]byte{5, test.reply, 0, 1, 127, 0, 0, 1, 0x30, 0x39})
+			return test.reply == 0
+		})
+		proxy.Scheme = "socks5"
+		proxy.User = test.user
+		conn, err := ProxyDial(&net.Dialer{Timeout: time.Second}, proxy, test.addr)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if err == nil {
+			testEcho(t, conn)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package helpers
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	. "github.com/hotid/streamsurfer/internal/pkg/structures"
 	"math/rand"
@@ -36,17 +38,28 @@ func NewTimeoutClient(args ...interface{}) *http.Client {
 
 	return &http.Client{
 		Transport: &http.Transport{
-			Dial: TimeoutDialer(config),
+			DialContext: TimeoutDialer(config),
 		},
 	}
 }
 
-func TimeoutDialer(config *HTTPConfig) func(net, addr string) (c net.Conn, err error) {
-	return func(netw, addr string) (net.Conn, error) {
-		conn, err := net.DialTimeout(netw, addr, config.ConnectTimeout)
+// Dialer keeps the context of the request so DNS and connect phases traced by httptrace.
+func TimeoutDialer(config *HTTPConfig) func(ctx context.Context, net, addr string) (c net.Conn, err error) {
+	return func(ctx context.Context, netw, addr string) (net.Conn, error) {
+		if config.Network != "" {
+			netw = config.Network
+		}
+		dialer := &net.Dialer{Timeout: config.ConnectTimeout}
+		if config.LocalAddr != nil {
+			dialer.LocalAddr = &net.TCPAddr{IP: config.LocalAddr}
+		}
+		conn, err := dialer.DialContext(ctx, netw, addr)
 		if err != nil {
 			return nil, err
 		}
+		if config.KeepAlive {
+			return conn, nil
+		}
 		conn.SetDeadline(time.Now().Add(config.ReadWriteTimeout))
 		tcp_conn := conn.(*net.TCPConn)
 		tcp_conn.SetLinger(0) // because we have mass connects/disconnects
@@ -54,6 +67,36 @@ func TimeoutDialer(config *HTTPConfig) func(net, addr string) (c net.Conn, err e
 	}
 }
 
+// Client with the network path of the group: proxy, source address and address family.
+// Connections to `host` go to `edge` address when set (direct connections only).
+// Keep-alive client should be reused between requests.
+func NewRouteClient(config *HTTPConfig, host, edge string) *http.Client {
+	transport := &http.Transport{DialContext: EdgeDialer(config, host, edge)}
+	if config.Proxy != nil {
+		transport.Proxy = http.ProxyURL(config.Proxy)
+	}
+	if config.InsecureTLS {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if config.KeepAlive {
+		transport.IdleConnTimeout = 90 * time.Second
+		return &http.Client{Transport: transport, Timeout: config.ConnectTimeout + config.ReadWriteTimeout}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// Dialer connects to the edge address instead of the resolved address of the host. Other hosts
+// (after redirects) resolved as usual. Host header and SNI of the request not changed.
+func EdgeDialer(config *HTTPConfig, host, edge string) func(ctx context.Context, net, addr string) (c net.Conn, err error) {
+	dial := TimeoutDialer(config)
+	return func(ctx context.Context, netw, addr string) (net.Conn, error) {
+		if addrHost, port, err := net.SplitHostPort(addr); err == nil && addrHost == host && edge != "" {
+			addr = net.JoinHostPort(edge, port)
+		}
+		return dial(ctx, netw, addr)
+	}
+}
+
 // Returns proper user agent string for the HTTP-headers.
 func UserAgent(cfg *Config) string {
 	if len(cfg.UserAgents) > 0 {
@@ -79,6 +122,14 @@ func String2StreamErr(s string) ErrType {
 		return SLOW
 	case "veryslow":
 		return VERYSLOW
+	case "latency": // HLS specific
+		return LATENCY
+	case "verylatency": // HLS specific
+		return VERYLATENCY
+	case "badpart": // LL-HLS specific
+		return BADPART
+	case "badreload": // LL-HLS specific
+		return BADRELOAD
 	case "badstatus":
 		return BADSTATUS
 	case "baduri":
@@ -87,10 +138,62 @@ func String2StreamErr(s string) ErrType {
 		return LISTEMPTY
 	case "badformat": // HLS specific
 		return BADFORMAT
+	case "badseguri": // HLS specific
+		return BADSEGURI
+	case "badgroup": // HLS specific
+		return BADGROUP
+	case "nosequence": // HLS specific
+		return NOSEQUENCE
+	case "badduration": // HLS specific
+		return BADDURATION
+	case "staleplaylist": // HLS specific
+		return STALEPLAYLIST
+	case "durmismatch":
+		return DURMISMATCH
+	case "nofaststart": // MP4 specific
+		return NOFASTSTART
+	case "headlength":
+		return HEADLENGTH
+	case "certexpires":
+		return CERTEXPIRES
+	case "tsdiscont":
+		return TSDISCONT
+	case "badts":
+		return BADTS
+	case "badbox":
+		return BADBOX
+	case "baddecodetime":
+		return BADDECODETIME
+	case "badkey": // HLS specific
+		return BADKEY
+	case "baddecrypt": // HLS specific
+		return BADDECRYPT
+	case "badmanifest": // HDS specific
+		return BADMANIFEST
+	case "badbootstrap": // HDS specific
+		return BADBOOTSTRAP
+	case "badfragment": // HDS specific
+		return BADFRAGMENT
+	case "badmpd": // DASH specific
+		return BADMPD
+	case "stalempd": // DASH specific
+		return STALEMPD
+	case "baddynamic": // DASH specific
+		return BADDYNAMIC
+	case "badsmooth": // MSS specific
+		return BADSMOOTH
+	case "nodata": // ICY specific
+		return NODATA
+	case "badsdp": // RTSP specific
+		return BADSDP
+	case "badrtmp": // RTMP specific
+		return BADRTMP
 	case "ttlexpired":
 		return TTLEXPIRED
 	case "rtimeout":
 		return RTIMEOUT
+	case "connreset":
+		return CONNRESET
 	case "error":
 		return ERROR_LEVEL
 	case "ctimeout":
@@ -99,10 +202,22 @@ func String2StreamErr(s string) ErrType {
 		return BADLENGTH
 	case "bodyread":
 		return BODYREAD
+	case "badrange": // Widevine specific
+		return BADRANGE
+	case "lowbitrate": // ICY specific
+		return LOWBITRATE
 	case "critical":
 		return CRITICAL_LEVEL
 	case "refused":
 		return REFUSED
+	case "dnsfail":
+		return DNSFAIL
+	case "tlsfail":
+		return TLSFAIL
+	case "badcert":
+		return BADCERT
+	case "redirectloop":
+		return REDIRECTLOOP
 	default:
 		return UNKERR
 	}
@@ -118,6 +233,18 @@ func StreamType2String(t StreamType) string {
 		return "hds"
 	case WV:
 		return "wv"
+	case DASH:
+		return "dash"
+	case MSS:
+		return "mss"
+	case MP4:
+		return "mp4"
+	case ICY:
+		return "icy"
+	case RTSP:
+		return "rtsp"
+	case RTMP:
+		return "rtmp"
 	case HTTP:
 		return "http"
 	default:
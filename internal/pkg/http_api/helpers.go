@@ -4,6 +4,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"github.com/hotid/streamsurfer/internal/pkg/structures"
+	"strings"
+	"time"
 )
 
 func href(url, text string, opts ...string) string {
@@ -21,6 +23,59 @@ func span(text, class string) string {
 	return fmt.Sprintf("<span class=\"%s\">%s</span>", class, text)
 }
 
+// Stacked bar of HTTP request phases. Width of each phase relative to the sum of all phases.
+func timingBar(timing *structures.MetaTiming) string {
+	if timing == nil {
+		return ""
+	}
+	phases := []struct {
+		name, class string
+		took        time.Duration
+	}{
+		{"DNS", "bar-info", timing.DNS},
+		{"connect", "bar-success", timing.Connect},
+		{"TLS", "bar-warning", timing.TLS},
+		{"TTFB", "bar-danger", timing.TTFB},
+		{"transfer", "bar-transfer", timing.Transfer},
+	}
+	var total time.Duration
+	for _, phase := range phases {
+		total += phase.took
+	}
+	if total <= 0 {
+		return ""
+	}
+	bar := "<div class=\"progress timing\">"
+	if timing.Reused {
+		bar = "<div class=\"progress timing\" title=\"reused connection\">"
+	}
+	for _, phase := range phases {
+		if phase.took > 0 {
+			bar += fmt.Sprintf("<div class=\"bar %s\" title=\"%s %s\" style=\"width: %.1f%%\"></div>", phase.class, phase.name, phase.took, float64(phase.took)*100/float64(total))
+		}
+	}
+	return bar + "</div>"
+}
+
+// Upstream path, address family, connection mode and edge of the check for the history table.
+func routeLabel(route structures.Route) string {
+	var parts []string
+
+	if route.Tag != "" {
+		parts = append(parts, span(route.Tag, "label label-info"))
+	}
+	if route.Family != "" {
+		parts = append(parts, span(route.Family, "label"))
+	}
+	if route.Pool != "" {
+		parts = append(parts, span(route.Pool, "label"))
+	}
+	if route.Edge != "" {
+		parts = append(parts, route.Edge)
+	}
+	return strings.Join(parts, " ")
+}
+
 func bytewe(res []byte, err error) []byte {
 	return res
 }
@@ -63,6 +63,10 @@ func HttpAPI(config *Config) {
 	r.HandleFunc("/mon/error/{group}/{stream}/{astype:int|str}", HandleHTTP(monError)).Methods("GET", "HEAD")
 	// числовое значение ошибки для выбранных группы и канала в диапазоне errlevel from-upto
 	r.HandleFunc("/mon/error/{group}/{stream}/{fromerrlevel:[a-z]+}-{uptoerrlevel:[a-z]+}", HandleHTTP(monErrorLevel)).Methods("GET")
+	// address family the last check of the stream failed over while another family works (v4, v6 or empty)
+	r.HandleFunc("/mon/family/{group}/{stream}", HandleHTTP(monFamily)).Methods("GET", "HEAD")
+	// задержка прямого эфира в секундах по последней проверке выбранных группы и канала
+	r.HandleFunc("/mon/latency/{group}/{stream}", HandleHTTP(monLatency)).Methods("GET", "HEAD")
 
 	/* Reports for humans
 	 */
@@ -70,6 +74,10 @@ func HttpAPI(config *Config) {
 	r.HandleFunc("/rpt", HandleHTTP(ReportIndex)).Methods("GET")
 	r.HandleFunc("/rpt/", HandleHTTP(ReportIndex)).Methods("GET")
 	r.HandleFunc("/rpt/{rptid:[0-9]+}", HandleHTTP(ReportStreamErrors)).Methods("GET")
+	// Сертификаты TLS по хостам
+	r.HandleFunc("/rpt/certs", HandleHTTP(ReportCerts)).Methods("GET")
+	// Адреса CDN, на которых поток сбоит, когда на других работает
+	r.HandleFunc("/rpt/edges", HandleHTTP(ReportEdges)).Methods("GET")
 
 	// Obsoleted reports with old API:
 	// r.HandleFunc("/rprt", rprtMainPage).Methods("GET")
@@ -110,6 +118,12 @@ func rootAPI(res http.ResponseWriter, req *http.Request, vars map[string]string)
 	data["totalHLSMonPoints"] = StatsGlobals.TotalHLSMonitoringPoints
 	data["totalHDSMonPoints"] = StatsGlobals.TotalHDSMonitoringPoints
 	data["totalHTTPMonPoints"] = StatsGlobals.TotalHTTPMonitoringPoints
+	data["totalDASHMonPoints"] = StatsGlobals.TotalDASHMonitoringPoints
+	data["totalMSSMonPoints"] = StatsGlobals.TotalMSSMonitoringPoints
+	data["totalMP4MonPoints"] = StatsGlobals.TotalMP4MonitoringPoints
+	data["totalICYMonPoints"] = StatsGlobals.TotalICYMonitoringPoints
+	data["totalRTSPMonPoints"] = StatsGlobals.TotalRTSPMonitoringPoints
+	data["totalRTMPMonPoints"] = StatsGlobals.TotalRTMPMonitoringPoints
 	Page.ExecuteTemplate(res, "index", data)
 }
 
@@ -137,7 +151,7 @@ func monError(res http.ResponseWriter, req *http.Request, vars map[string]string
 			case "str":
 				res.Write([]byte(StreamErr2String(result.ErrType)))
 			case "int":
-				res.Write([]byte(strconv.Itoa(int(result.ErrType))))
+				res.Write([]byte(strconv.FormatUint(uint64(result.ErrType.Code()), 10)))
 			}
 		} else { // пока проверки не проводились, считаем, что всё ок. Чего зря беспокоиться?
 			switch vars["astype"] {
@@ -188,6 +202,54 @@ func monErrorLevel(res http.ResponseWriter, req *http.Request, vars map[string]s
 	}
 }
 
+// Webhandler. Returns text/plain address family (v4 or v6) the last check of the stream failed over
+// while the check over another family works. Empty when the stream fails over all families or works.
+func monFamily(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	res.Header().Set("Server", SERVER)
+	res.Header().Set("Content-Type", "text/plain")
+
+	if vars["group"] != "" && vars["stream"] != "" {
+		streamKey, err := KeyFromHex(vars["stream"])
+		if err != nil {
+			return
+		}
+		if !StatsGlobals.MonitoringState { // пока мониторинг остановлен, считаем, что всё ок
+			return
+		}
+		res.Write([]byte(LoadStats(streamKey).FamilyFail))
+	} else {
+		http.Error(res, "Bad parameters in query.", http.StatusBadRequest)
+	}
+}
+
+// Webhandler. Returns text/plain value of the live latency in seconds by the last check of the stream.
+// Latency measured by EXT-X-PROGRAM-DATE-TIME of HLS playlists, 0 if unknown.
+func monLatency(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	res.Header().Set("Server", SERVER)
+	res.Header().Set("Content-Type", "text/plain")
+
+	if vars["group"] != "" && vars["stream"] != "" {
+		streamKey, err := KeyFromHex(vars["stream"])
+		if err != nil {
+			return
+		}
+		var latency time.Duration
+		if results, err := LoadHistoryResults(streamKey); err == nil {
+			for i := len(results) - 1; i >= 0; i-- { // the newest check of the top level URL
+				if results[i].Master {
+					if results[i].HLS != nil {
+						latency = results[i].HLS.Latency
+					}
+					break
+				}
+			}
+		}
+		res.Write([]byte(strconv.FormatFloat(latency.Seconds(), 'f', 3, 64)))
+	} else {
+		http.Error(res, "Bad parameters in query.", http.StatusBadRequest)
+	}
+}
+
 // func rprtMainPage(res http.ResponseWriter, req *http.Request) {
 // 	res.Header().Set("Server", SERVER)
 // 	res.Write(ReportMainPage())
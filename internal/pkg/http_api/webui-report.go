@@ -1,12 +1,15 @@
 package http_api
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"github.com/grafov/m3u8"
 	"github.com/hotid/streamsurfer/internal/pkg/analyzer"
 	. "github.com/hotid/streamsurfer/internal/pkg/logging"
 	. "github.com/hotid/streamsurfer/internal/pkg/stats"
 	. "github.com/hotid/streamsurfer/internal/pkg/structures"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -33,9 +36,9 @@ func ActivityIndex(res http.ResponseWriter, req *http.Request, vars map[string]s
 		data["title"] = "List of streams"
 	}
 	if vars["group"] != "" {
-		data["thead"] = []string{"Name", "Checks", "Avg. resp.time", "Problems (3 min)", "Problems (last 15 min)", "Problems (last 1 hour)"}
+		data["thead"] = []string{"Name", "Checks", "Avg. resp.time", "Problems (3 min)", "Problems (last 15 min)", "Problems (last 1 hour)", "Fails over"}
 	} else {
-		data["thead"] = []string{"Group", "Name", "Checks", "Avg. resp.time", "Problems (last 3 min)", "Problems (last 15 min)", "Problems (last 1 hour)"}
+		data["thead"] = []string{"Group", "Name", "Checks", "Avg. resp.time", "Problems (last 3 min)", "Problems (last 15 min)", "Problems (last 1 hour)", "Fails over"}
 	}
 	data["isactivity"] = true
 	for groupName, groupData := range cfg.GroupParams {
@@ -85,6 +88,10 @@ func ActivityIndex(res http.ResponseWriter, req *http.Request, vars map[string]s
 			if severity == "" && errcountShort > 0 {
 				severity = "warning"
 			}
+			family := ""
+			if stats.FamilyFail != "" { // the stream works over another address family
+				family = span(stats.FamilyFail+" only", "label label-important")
+			}
 			if vars["group"] != "" {
 				tbody = append(tbody, []string{
 					severity,
@@ -93,7 +100,8 @@ func ActivityIndex(res http.ResponseWriter, req *http.Request, vars map[string]s
 					"0",
 					strconv.Itoa(errcountShort),
 					strconv.Itoa(errcountMid),
-					strconv.Itoa(errcountLong)})
+					strconv.Itoa(errcountLong),
+					family})
 			} else {
 				tbody = append(tbody, []string{
 					severity,
@@ -103,7 +111,8 @@ func ActivityIndex(res http.ResponseWriter, req *http.Request, vars map[string]s
 					"0",
 					strconv.Itoa(errcountShort),
 					strconv.Itoa(errcountMid),
-					strconv.Itoa(errcountLong)})
+					strconv.Itoa(errcountLong),
+					family})
 			}
 		}
 	}
@@ -140,30 +149,145 @@ func ActivityStreamInfo(res http.ResponseWriter, req *http.Request, vars map[str
 	if err == nil {
 		data["url"] = last.URI
 	}
+	data["listtype"] = "unknown"
+	data["profiles"] = "-"
+	data["targetduration"] = "-"
+	data["chunksduration"] = "-"
+	data["declaredduration"] = "-"
+	data["latency"] = "-"
+	data["connmodes"] = []connMode{}
+	data["familycount"] = 0
+	if results, err := LoadHistoryResults(streamKey); err == nil {
+		var realmin, realmax, declmin, declmax float64
+		var lastMedia *MetaMedia
+		modes := make(map[string]*connMode)
+		for i := len(results) - 1; i >= 0; i-- { // from the newest
+			val := results[i]
+			if val.FamilyFail != "" {
+				data["familycount"] = data["familycount"].(int) + 1
+			}
+			if val.Master && val.Timing != nil && val.Route.Pool != "" { // nested checks reuse the connection of the parent
+				mode, ok := modes[val.Route.Pool]
+				if !ok {
+					mode = &connMode{Pool: val.Route.Pool}
+					modes[val.Route.Pool] = mode
+				}
+				mode.add(val)
+			}
+			if val.HLS != nil && val.Master && val.HLS.Latency != 0 && data["latency"] == "-" {
+				data["latency"] = val.HLS.Latency.String()
+			}
+			if val.HLS != nil && val.Master && val.HLS.ListType == m3u8.MASTER && data["profiles"] == "-" {
+				data["profiles"] = strconv.Itoa(len(val.HLS.DeepLinks))
+			}
+			if val.HLS != nil && val.HLS.ListType == m3u8.MEDIA && data["listtype"] == "unknown" {
+				if val.HLS.Live {
+					data["listtype"] = "LIVE"
+				} else {
+					data["listtype"] = "VOD"
+				}
+				data["targetduration"] = fmt.Sprintf("%gs", val.HLS.TargetDuration)
+			}
+			if val.Media == nil {
+				continue
+			}
+			if lastMedia == nil {
+				lastMedia = val.Media
+			}
+			if val.Media.Duration > 0 {
+				if realmin == 0 || val.Media.Duration < realmin {
+					realmin = val.Media.Duration
+				}
+				if val.Media.Duration > realmax {
+					realmax = val.Media.Duration
+				}
+			}
+			if val.Media.Declared > 0 {
+				if declmin == 0 || val.Media.Declared < declmin {
+					declmin = val.Media.Declared
+				}
+				if val.Media.Declared > declmax {
+					declmax = val.Media.Declared
+				}
+			}
+		}
+		if realmax > 0 {
+			data["chunksduration"] = fmt.Sprintf("%.3fs - %.3fs", realmin, realmax)
+		}
+		if declmax > 0 {
+			data["declaredduration"] = fmt.Sprintf("%.3fs - %.3fs", declmin, declmax)
+		}
+		for _, pool := range []string{"cold", "warm"} {
+			if mode, ok := modes[pool]; ok {
+				data["connmodes"] = append(data["connmodes"].([]connMode), *mode)
+			}
+		}
+		if lastMedia != nil {
+			data["container"] = lastMedia.Container
+			data["streams"] = strings.Join(lastMedia.Streams, ", ")
+			data["problems"] = lastMedia.Problems
+		}
+	}
 	data["slowcount"] = 0
+	data["latencycount"] = 0
 	data["timeoutcount"] = 0
 	data["httpcount"] = 0
 	data["formatcount"] = 0
+	data["mediacount"] = 0
 	hist, err := LoadHistoryErrors(streamKey, 24*time.Hour)
 	if err == nil {
 		for _, val := range hist {
 			switch val {
 			case SLOW, VERYSLOW:
 				data["slowcount"] = data["slowcount"].(int) + 1
+			case LATENCY, VERYLATENCY:
+				data["latencycount"] = data["latencycount"].(int) + 1
 			case CTIMEOUT, RTIMEOUT:
 				data["timeoutcount"] = data["timeoutcount"].(int) + 1
-			case BADLENGTH, BODYREAD, REFUSED, BADSTATUS, BADURI:
+			case BADLENGTH, HEADLENGTH, BODYREAD, BADRANGE, CONNRESET, REFUSED, DNSFAIL, TLSFAIL, BADCERT, CERTEXPIRES, REDIRECTLOOP, BADSTATUS, BADURI:
 				data["httpcount"] = data["httpcount"].(int) + 1
-			case LISTEMPTY, BADFORMAT:
+			case LISTEMPTY, BADFORMAT, BADSEGURI, BADGROUP, NOSEQUENCE, BADDURATION, STALEPLAYLIST, BADPART, BADRELOAD, BADMANIFEST, BADBOOTSTRAP, BADMPD, STALEMPD, BADDYNAMIC, BADSMOOTH, BADSDP, BADRTMP:
 				data["formatcount"] = data["formatcount"].(int) + 1
+			case BADTS, TSDISCONT, DURMISMATCH, NOFASTSTART, LOWBITRATE, NODATA, BADBOX, BADDECODETIME, BADKEY, BADDECRYPT, BADFRAGMENT:
+				data["mediacount"] = data["mediacount"].(int) + 1
 			}
 		}
 	}
 	Page.ExecuteTemplate(res, "report-stream-info", data)
 }
 
+// Latency of the top level checks made in cold (new connection) or warm (reused connection) mode.
+type connMode struct {
+	Pool    string
+	Checks  int
+	Reused  int
+	elapsed time.Duration
+	ttfb    time.Duration
+}
+
+func (m *connMode) add(val KeepedResult) {
+	m.Checks++
+	m.elapsed += val.Elapsed
+	m.ttfb += val.Timing.TTFB
+	if val.Timing.Reused {
+		m.Reused++
+	}
+}
+
+func (m connMode) Elapsed() time.Duration {
+	return (m.elapsed / time.Duration(m.Checks)).Round(time.Millisecond)
+}
+
+func (m connMode) TTFB() time.Duration {
+	return (m.ttfb / time.Duration(m.Checks)).Round(time.Millisecond)
+}
+
+func (m connMode) ReusedPercent() string {
+	return fmt.Sprintf("%.0f%%", float64(m.Reused)*100/float64(m.Checks))
+}
+
 func ActivityStreamHistory(res http.ResponseWriter, req *http.Request, vars map[string]string) {
-	var severity, checktype string
+	var severity, checktype, throughput string
 	var tbody [][]string
 
 	streamKey, err := KeyFromHex(vars["stream"])
@@ -211,7 +335,7 @@ FullHistory:
 	data["title"] = fmt.Sprintf("%s/%s checks history", vars["group"], vars["stream"])
 	data["isactivity"] = true
 	data["stream"] = vars["stream"]
-	data["thead"] = []string{"Check type", "Date/time", "Check result", "HTTP status", "Time elapsed", "Content length", "Raw result"}
+	data["thead"] = []string{"Check type", "Date/time", "Check result", "HTTP status", "Time elapsed", "Timing", "Content length", "Throughput", "Route", "Raw result"}
 
 	switch vars["mode"] {
 	case "history":
@@ -234,19 +358,36 @@ FullHistory:
 		default:
 			severity = "success"
 		}
-		if val.Master { // TODO пофиксить для HTTP/HDS-проверок
+		switch {
+		case val.Kind != "":
+			checktype = val.Kind
+		case val.Master: // TODO пофиксить для HTTP/HDS-проверок
 			checktype = "master"
-		} else {
+		default:
 			checktype = "media"
 		}
+		if val.HLS != nil && val.HLS.Rendition != "" {
+			checktype = fmt.Sprintf("%s %s", checktype, val.HLS.Rendition)
+		}
+		errtext := StreamErr2String(val.ErrType)
+		if val.FamilyFail != "" {
+			errtext = fmt.Sprintf("%s (over %s only)", errtext, val.FamilyFail)
+		}
+		throughput = ""
+		if val.Throughput > 0 {
+			throughput = fmt.Sprintf("%.1f KB/s", float64(val.Throughput)/1024)
+		}
 		tbody = append(tbody,
 			[]string{severity,
 				span(checktype, "label"),
 				val.Started.Format("2006-01-02 15:04:05 -0700"),
-				StreamErr2String(val.ErrType),
+				errtext,
 				val.HTTPStatus,
 				val.Elapsed.String(),
+				timingBar(val.Timing),
 				strconv.FormatInt(val.ContentLength, 10),
+				throughput,
+				routeLabel(val.Route),
 				href(fmt.Sprintf("%d/raw", val.Started.UnixNano()), "show raw result")})
 	}
 	data["tbody"] = tbody
@@ -282,6 +423,124 @@ func ReportIndex(res http.ResponseWriter, req *http.Request, vars map[string]str
 	Page.ExecuteTemplate(res, "report-index", data)
 }
 
+// Edge fails when the part of its failed checks exceeds the part of the best edge of the stream by the margin.
+const edgeFailMargin = 0.2
+
+// Edges of streams where some edges fail while others succeed. Streams checked through a single edge skipped.
+func ReportEdges(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	var tbody [][]string
+	var severity string
+
+	data := make(map[string]interface{})
+	data["title"] = "Failing edges"
+	data["isreport"] = true
+	data["thead"] = []string{"Stream", "Edge", "Checks", "Errors", "Error rate", "Last error", "Last error at", "Last checked"}
+	streams := make(map[Key][]EdgeStat)
+	for _, edge := range LoadEdges() {
+		streams[edge.StreamKey] = append(streams[edge.StreamKey], edge)
+	}
+	for _, edges := range streams {
+		if len(edges) < 2 {
+			continue
+		}
+		best := 1.
+		failing := false
+		for _, edge := range edges {
+			if rate := float64(edge.Errors) / float64(edge.Checks); rate < best {
+				best = rate
+			}
+		}
+		for _, edge := range edges {
+			if float64(edge.Errors)/float64(edge.Checks)-best >= edgeFailMargin {
+				failing = true
+			}
+		}
+		if !failing {
+			continue
+		}
+		sort.Slice(edges, func(i, j int) bool {
+			return float64(edges[i].Errors)/float64(edges[i].Checks) > float64(edges[j].Errors)/float64(edges[j].Checks)
+		})
+		for _, edge := range edges {
+			var lastError, lastErrorAt string
+			rate := float64(edge.Errors) / float64(edge.Checks)
+			switch {
+			case rate-best >= edgeFailMargin:
+				severity = "error"
+			case edge.Errors > 0:
+				severity = "warning"
+			default:
+				severity = "success"
+			}
+			if edge.Errors > 0 {
+				lastError = StreamErr2String(edge.LastErr)
+				lastErrorAt = edge.LastError.Format("2006-01-02 15:04:05 -0700")
+			}
+			tbody = append(tbody,
+				[]string{severity,
+					href(fmt.Sprintf("/act/%x/%x", sha256.Sum256([]byte(edge.Group)), edge.StreamKey), fmt.Sprintf("%s/%s", edge.Group, edge.Name)),
+					edge.Edge,
+					strconv.FormatInt(edge.Checks, 10),
+					strconv.FormatInt(edge.Errors, 10),
+					fmt.Sprintf("%.1f%%", rate*100),
+					lastError,
+					lastErrorAt,
+					edge.LastCheck.Format("2006-01-02 15:04:05 -0700")})
+		}
+	}
+	data["tbody"] = tbody
+	Page.ExecuteTemplate(res, "report-table", data)
+}
+
+// Summary of TLS certificates by host. Hosts with the nearest expiry listed first.
+func ReportCerts(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	var tbody [][]string
+	var severity string
+
+	data := make(map[string]interface{})
+	data["title"] = "TLS certificates by host"
+	data["isreport"] = true
+	data["thead"] = []string{"Host", "Expires", "Days left", "Issuer", "Subject", "Alternative names", "Host match", "Verified", "TLS", "Last checked", "Stream"}
+	certs := LoadCerts()
+	sort.Slice(certs, func(i, j int) bool { return certs[i].TLS.Expires.Before(certs[j].TLS.Expires) })
+	for _, cert := range certs {
+		var issuer, subject, names string
+		daysLeft := int(time.Until(cert.TLS.Expires).Hours() / 24)
+		warnDays := cfg.Params(cert.Group).CertWarning
+		if warnDays <= 0 {
+			warnDays = DefaultCertWarning
+		}
+		switch {
+		case daysLeft < 0, !cert.TLS.Verified, !cert.TLS.HostMatch:
+			severity = "error"
+		case daysLeft < warnDays:
+			severity = "warning"
+		default:
+			severity = "success"
+		}
+		if len(cert.TLS.Chain) > 0 {
+			issuer = cert.TLS.Chain[0].Issuer
+			subject = cert.TLS.Chain[0].Subject
+			names = strings.Join(cert.TLS.Chain[0].DNSNames, ", ")
+		}
+		tbody = append(tbody,
+			[]string{severity,
+				cert.TLS.Host,
+				cert.TLS.Expires.Format("2006-01-02 15:04:05 -0700"),
+				strconv.Itoa(daysLeft),
+				issuer,
+				subject,
+				names,
+				strconv.FormatBool(cert.TLS.HostMatch),
+				strconv.FormatBool(cert.TLS.Verified),
+				cert.TLS.Version,
+				cert.Checked.Format("2006-01-02 15:04:05 -0700"),
+				href(fmt.Sprintf("/act/%x/%x", sha256.Sum256([]byte(cert.Group)), cert.StreamKey), fmt.Sprintf("%s/%s", cert.Group, cert.Name))})
+	}
+	data["tbody"] = tbody
+	Page.ExecuteTemplate(res, "report-table", data)
+}
+
 func ReportStreamErrors(res http.ResponseWriter, req *http.Request, vars map[string]string) {
 	var tbody [][]string
 	var severity string
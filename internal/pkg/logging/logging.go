@@ -95,6 +95,14 @@ func StreamErr2String(err ErrType) string {
 		return "slow response"
 	case VERYSLOW:
 		return "very slow response"
+	case LATENCY: // HLS specific
+		return "high live latency"
+	case VERYLATENCY: // HLS specific
+		return "very high live latency"
+	case BADPART: // LL-HLS specific
+		return "bad partial segment"
+	case BADRELOAD: // LL-HLS specific
+		return "blocking reload failed"
 	case BADSTATUS:
 		return "bad status"
 	case BADURI:
@@ -103,18 +111,82 @@ func StreamErr2String(err ErrType) string {
 		return "list empty"
 	case BADFORMAT: // HLS specific
 		return "bad format"
+	case BADSEGURI: // HLS specific
+		return "bad segment URI"
+	case BADGROUP: // HLS specific
+		return "missing rendition group"
+	case NOSEQUENCE: // HLS specific
+		return "no media sequence"
+	case BADDURATION: // HLS specific
+		return "segment longer than target duration"
+	case STALEPLAYLIST: // HLS specific
+		return "stale playlist"
+	case DURMISMATCH:
+		return "segment duration mismatch"
+	case NOFASTSTART: // MP4 specific
+		return "moov after mdat"
+	case HEADLENGTH:
+		return "HEAD Content-Length missing"
+	case CERTEXPIRES:
+		return "TLS certificate expires soon"
+	case TSDISCONT:
+		return "MPEG-TS discontinuity"
+	case BADTS:
+		return "broken MPEG-TS"
+	case BADBOX:
+		return "broken MP4 box"
+	case BADDECODETIME:
+		return "decode time discontinuity"
+	case BADKEY: // HLS specific
+		return "bad encryption key"
+	case BADDECRYPT: // HLS specific
+		return "decryption failed"
+	case BADMANIFEST: // HDS specific
+		return "bad manifest"
+	case BADBOOTSTRAP: // HDS specific
+		return "bad bootstrap info"
+	case BADFRAGMENT: // HDS specific
+		return "broken HDS fragment"
+	case BADMPD: // DASH specific
+		return "bad MPD"
+	case STALEMPD: // DASH specific
+		return "stale MPD timeline"
+	case BADDYNAMIC: // DASH specific
+		return "bad dynamic MPD"
+	case BADSMOOTH: // MSS specific
+		return "bad Smooth Streaming manifest"
+	case NODATA: // ICY specific
+		return "connected but no data"
+	case BADSDP: // RTSP specific
+		return "bad SDP"
+	case BADRTMP: // RTMP specific
+		return "RTMP connect failed"
+	case LOWBITRATE: // ICY specific
+		return "audio bitrate lower than advertised"
 	case TTLEXPIRED:
 		return "TTL expired"
 	case RTIMEOUT:
 		return "timeout on read"
+	case CONNRESET:
+		return "connection reset"
 	case CTIMEOUT:
 		return "connection timeout"
 	case BADLENGTH:
 		return "bad content length value"
 	case BODYREAD:
 		return "response body error"
+	case BADRANGE: // Widevine specific
+		return "range requests not supported"
 	case REFUSED:
 		return "connection refused"
+	case DNSFAIL:
+		return "DNS resolution failed"
+	case TLSFAIL:
+		return "TLS handshake failed"
+	case BADCERT:
+		return "bad TLS certificate"
+	case REDIRECTLOOP:
+		return "redirect loop"
 	default:
 		return "unknown"
 	}
@@ -0,0 +1,53 @@
+package media
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Decrypt segment encrypted with HLS AES-128 method (AES-128-CBC with PKCS7 padding).
+func DecryptAES128(data, key, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, errors.New("bad IV length")
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted data is not multiple of the block size")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("bad PKCS7 padding")
+	}
+	return plain[:len(plain)-padding], nil
+}
+
+// Get IV for the segment. It is IV attribute of EXT-X-KEY if presents or media sequence
+// number of the segment as big-endian 128-bit integer.
+func SegmentIV(attr string, seqId uint64) ([]byte, error) {
+	if attr == "" {
+		iv := make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], seqId)
+		return iv, nil
+	}
+	if !strings.HasPrefix(attr, "0x") && !strings.HasPrefix(attr, "0X") {
+		return nil, errors.New("IV must be hexadecimal")
+	}
+	iv, err := hex.DecodeString(attr[2:])
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, errors.New("bad IV length")
+	}
+	return iv, nil
+}
@@ -0,0 +1,72 @@
+package media
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+// Helper. Encrypt data with AES-128-CBC and PKCS7 padding as HLS packager does.
+func encryptAES128(plain, key, iv []byte) []byte {
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	data := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	block, _ := aes.NewCipher(key)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return data
+}
+
+func TestDecryptAES128(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := make([]byte, aes.BlockSize)
+	plain := bytes.Repeat([]byte{0x47}, 188)
+	encrypted := encryptAES128(plain, key, iv)
+	badPadding := append([]byte{}, encrypted...)
+	badPadding[len(badPadding)-aes.BlockSize-1] ^= 0xff // corrupts the last plain block
+
+	tests := []struct {
+		name      string
+		data, key []byte
+		iv        []byte
+		plain     []byte
+		err       bool
+	}{
+		{name: "segment", data: encrypted, key: key, iv: iv, plain: plain},
+		{name: "block aligned segment", data: encryptAES128(plain[:32], key, iv), key: key, iv: iv, plain: plain[:32]},
+		{name: "padding only", data: encryptAES128(nil, key, iv), key: key, iv: iv, plain: []byte{}},
+		{name: "wrong key", data: encrypted, key: []byte("fedcba9876543210"), iv: iv, err: true},
+		{name: "bad padding", data: badPadding, key: key, iv: iv, err: true},
+		{name: "truncated", data: encrypted[:len(encrypted)-1], key: key, iv: iv, err: true},
+		{name: "empty", data: nil, key: key, iv: iv, err: true},
+		{name: "bad key length", data: encrypted, key: key[:10], iv: iv, err: true},
+		{name: "bad IV length", data: encrypted, key: key, iv: iv[:8], err: true},
+	}
+	for _, test := range tests {
+		result, err := DecryptAES128(test.data, test.key, test.iv)
+		if (err != nil) != test.err || !test.err && !bytes.Equal(result, test.plain) {
+			t.Errorf("%s: got %d bytes, error %v", test.name, len(result), err)
+		}
+	}
+}
+
+func TestSegmentIV(t *testing.T) {
+	tests := []struct {
+		attr  string
+		seqId uint64
+		iv    []byte
+		err   bool
+	}{
+		{attr: "", seqId: 0x0102, iv: append(make([]byte, 14), 1, 2)},
+		{attr: "0x000102030405060708090A0B0C0D0E0F", iv: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}},
+		{attr: "0X000102030405060708090a0b0c0d0e0f", iv: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}},
+		{attr: "000102030405060708090A0B0C0D0E0F", err: true},
+		{attr: "0x0001", err: true},
+		{attr: "0xZZ0102030405060708090A0B0C0D0E", err: true},
+	}
+	for _, test := range tests {
+		iv, err := SegmentIV(test.attr, test.seqId)
+		if (err != nil) != test.err || !bytes.Equal(iv, test.iv) {
+			t.Errorf("SegmentIV(%q, %d) = %x, %v", test.attr, test.seqId, iv, err)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+)
+
+var ErrNoRunTables = errors.New("no segment or fragment run table")
+
+// HDS bootstrap info (`abst` box). Only the first segment and fragment run tables used.
+type Bootstrap struct {
+	Live              bool
+	Timescale         uint32
+	CurrentMediaTime  uint64
+	Segments          []SegmentRun
+	FragmentTimescale uint32
+	Fragments         []FragmentRun
+}
+
+// Entry of the segment run table (`asrt`).
+type SegmentRun struct {
+	FirstSegment        uint32
+	FragmentsPerSegment uint32
+}
+
+// Entry of the fragment run table (`afrt`).
+type FragmentRun struct {
+	FirstFragment  uint32
+	FirstTimestamp uint64
+	Duration       uint32
+	Discontinuity  uint8 // only for entries with zero duration
+}
+
+// Helper. Sequential reader of big-endian fields with bounds checking.
+type fieldReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *fieldReader) next(n int) []byte {
+	if r.err != nil || r.pos+n > len(r.data) {
+		r.err = ErrTruncatedBox
+		return make([]byte, n)
+	}
+	r.pos += n
+	return r.data[r.pos-n : r.pos]
+}
+
+func (r *fieldReader) u8() uint8   { return r.next(1)[0] }
+func (r *fieldReader) u32() uint32 { return binary.BigEndian.Uint32(r.next(4)) }
+func (r *fieldReader) u64() uint64 { return binary.BigEndian.Uint64(r.next(8)) }
+
+// Null terminated string.
+func (r *fieldReader) str() string {
+	for i := r.pos; i < len(r.data); i++ {
+		if r.data[i] == 0 {
+			s := string(r.data[r.pos:i])
+			r.pos = i + 1
+			return s
+		}
+	}
+	r.err = ErrTruncatedBox
+	return ""
+}
+
+// Skip counted list of strings.
+func (r *fieldReader) strs() {
+	for i := r.u8(); i > 0 && r.err == nil; i-- {
+		r.str()
+	}
+}
+
+// Parse bootstrap info. Data is the `abst` box with the header.
+func ParseBootstrap(data []byte) (*Bootstrap, error) {
+	abst := findBox(data, "abst")
+	if abst == nil {
+		return nil, fmt.Errorf("abst not found")
+	}
+	b := new(Bootstrap)
+	r := &fieldReader{data: abst}
+	r.next(4) // version and flags
+	r.u32()   // bootstrap info version
+	b.Live = r.u8()&0x20 != 0
+	b.Timescale = r.u32()
+	b.CurrentMediaTime = r.u64()
+	r.u64()  // SMPTE timecode offset
+	r.str()  // movie identifier
+	r.strs() // server entries
+	r.strs() // quality entries
+	r.str()  // DRM data
+	r.str()  // metadata
+	if r.err != nil {
+		return nil, r.err
+	}
+	for i := r.u8(); i > 0 && r.err == nil; i-- {
+		box := r.runTable()
+		if b.Segments == nil && box != nil {
+			b.Segments, r.err = parseSegmentRuns(box)
+		}
+	}
+	for i := r.u8(); i > 0 && r.err == nil; i-- {
+		box := r.runTable()
+		if b.Fragments == nil && box != nil {
+			b.FragmentTimescale, b.Fragments, r.err = parseFragmentRuns(box)
+		}
+	}
+	if r.err != nil {
+		return b, r.err
+	}
+	if len(b.Segments) == 0 || len(b.Fragments) == 0 {
+		return b, ErrNoRunTables
+	}
+	return b, nil
+}
+
+// Helper. Get the next child box (run table) of the bootstrap info.
+func (r *fieldReader) runTable() []byte {
+	if r.err != nil {
+		return nil
+	}
+	size, header, err := BoxHeader(r.data[r.pos:])
+	if err != nil || size < header {
+		r.err = ErrTruncatedBox
+		return nil
+	}
+	box := r.next(int(size))
+	return box[header:]
+}
+
+// Helper. Parse payload of `asrt` box.
+func parseSegmentRuns(asrt []byte) ([]SegmentRun, error) {
+	var runs []SegmentRun
+
+	r := &fieldReader{data: asrt}
+	r.next(4) // version and flags
+	r.strs()  // quality entries
+	for i := r.u32(); i > 0 && r.err == nil; i-- {
+		runs = append(runs, SegmentRun{FirstSegment: r.u32(), FragmentsPerSegment: r.u32()})
+	}
+	return runs, r.err
+}
+
+// Helper. Parse payload of `afrt` box.
+func parseFragmentRuns(afrt []byte) (uint32, []FragmentRun, error) {
+	var runs []FragmentRun
+
+	r := &fieldReader{data: afrt}
+	r.next(4) // version and flags
+	timescale := r.u32()
+	r.strs() // quality entries
+	for i := r.u32(); i > 0 && r.err == nil; i-- {
+		run := FragmentRun{FirstFragment: r.u32(), FirstTimestamp: r.u64(), Duration: r.u32()}
+		if run.Duration == 0 {
+			run.Discontinuity = r.u8()
+		}
+		runs = append(runs, run)
+	}
+	return timescale, runs, r.err
+}
+
+// Current media time in seconds.
+func (b *Bootstrap) MediaTime() float64 {
+	if b.Timescale == 0 {
+		return 0
+	}
+	return float64(b.CurrentMediaTime) / float64(b.Timescale)
+}
+
+// Get the newest completed fragment by the current media time with its segment number
+// and duration (sec). For VOD the last fragment of the presentation returned.
+func (b *Bootstrap) LastFragment() (segment, fragment uint32, duration float64) {
+	var run *FragmentRun
+
+	for i := range b.Fragments {
+		if b.Fragments[i].Duration > 0 {
+			run = &b.Fragments[i]
+		}
+	}
+	if run == nil {
+		return 0, 0, 0
+	}
+	fragment = run.FirstFragment
+	current := b.CurrentMediaTime
+	if b.Timescale > 0 && b.FragmentTimescale > 0 && b.Timescale != b.FragmentTimescale {
+		current = current * uint64(b.FragmentTimescale) / uint64(b.Timescale)
+	}
+	if current > run.FirstTimestamp {
+		if count := uint32((current - run.FirstTimestamp) / uint64(run.Duration)); count > 0 {
+			fragment += count - 1
+		}
+	}
+	if b.FragmentTimescale > 0 {
+		duration = float64(run.Duration) / float64(b.FragmentTimescale)
+	}
+	return b.segmentOf(fragment), fragment, duration
+}
+
+// Helper. Find segment number containing the fragment.
+func (b *Bootstrap) segmentOf(fragment uint32) uint32 {
+	first := b.Fragments[0].FirstFragment
+	for i, run := range b.Segments {
+		if run.FragmentsPerSegment == 0 {
+			continue
+		}
+		if i+1 < len(b.Segments) {
+			last := first + (b.Segments[i+1].FirstSegment-run.FirstSegment)*run.FragmentsPerSegment
+			if fragment >= last {
+				first = last
+				continue
+			}
+		}
+		return run.FirstSegment + (fragment-first)/run.FragmentsPerSegment
+	}
+	return 1
+}
+
+// Inspect F4F fragment: box structure and FLV tags inside `mdat`.
+func InspectF4F(data []byte, declared float64) *MetaMedia {
+	var mdat []byte
+
+	meta := &MetaMedia{Container: "f4f", Declared: declared, DecodeTime: -1}
+	boxes, err := ReadBoxes(data)
+	if err != nil {
+		meta.Broken = true
+		meta.Problems = append(meta.Problems, fmt.Sprintf("top level boxes: %s", err))
+	}
+	for _, b := range boxes {
+		if b.Type == "mdat" {
+			mdat = b.Data
+		}
+	}
+	if mdat == nil {
+		meta.Broken = true
+		meta.Problems = append(meta.Problems, "mdat not found")
+		return meta
+	}
+	if err := inspectFLVTags(mdat, meta); err != nil {
+		meta.Broken = true
+		meta.Problems = append(meta.Problems, fmt.Sprintf("FLV tags: %s", err))
+	}
+	checkDuration(meta)
+	return meta
+}
+
+// Helper. Walk FLV tags of the fragment. Duration measured by timestamps of video
+// or audio tags when no video found.
+func inspectFLVTags(data []byte, meta *MetaMedia) error {
+	var first, last [2]int64 // audio, video timestamps in ms
+	var found [2]bool
+
+	for offset := 0; offset < len(data); {
+		if offset+11 > len(data) {
+			return ErrTruncatedBox
+		}
+		tagType := data[offset] & 0x1f
+		size := int(data[offset+1])<<16 | int(data[offset+2])<<8 | int(data[offset+3])
+		timestamp := int64(data[offset+7])<<24 | int64(data[offset+4])<<16 | int64(data[offset+5])<<8 | int64(data[offset+6])
+		if offset+11+size+4 > len(data) {
+			return ErrTruncatedBox
+		}
+		var idx int
+		switch tagType {
+		case 8:
+			idx = 0
+		case 9:
+			idx = 1
+		case 18: // script data
+			offset += 11 + size + 4
+			continue
+		default:
+			return fmt.Errorf("unknown tag type %d at offset %d", tagType, offset)
+		}
+		if !found[idx] {
+			found[idx] = true
+			first[idx] = timestamp
+			meta.Streams = append(meta.Streams, []string{"audio", "video"}[idx])
+		}
+		last[idx] = timestamp
+		offset += 11 + size + 4 // tag header, data and previous tag size
+	}
+	switch {
+	case found[1]:
+		meta.Duration = float64(last[1]-first[1]) / 1000.
+	case found[0]:
+		meta.Duration = float64(last[0]-first[0]) / 1000.
+	}
+	return nil
+}
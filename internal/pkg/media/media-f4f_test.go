@@ -0,0 +1,170 @@
+package media
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// Helper. Big endian 64-bit field.
+func u64(v uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, v)
+	return data
+}
+
+// Helper. Segment run table box.
+func testAsrt(runs ...SegmentRun) []byte {
+	data := append(u32s(0), 0) // version and flags, no quality entries
+	data = append(data, u32s(uint32(len(runs)))...)
+	for _, run := range runs {
+		data = append(data, u32s(run.FirstSegment, run.FragmentsPerSegment)...)
+	}
+	return box("asrt", data)
+}
+
+// Helper. Fragment run table box.
+func testAfrt(timescale uint32, runs ...FragmentRun) []byte {
+	data := append(u32s(0, timescale), 0)
+	data = append(data, u32s(uint32(len(runs)))...)
+	for _, run := range runs {
+		data = append(data, u32s(run.FirstFragment)...)
+		data = append(data, u64(run.FirstTimestamp)...)
+		data = append(data, u32s(run.Duration)...)
+		if run.Duration == 0 {
+			data = append(data, run.Discontinuity)
+		}
+	}
+	return box("afrt", data)
+}
+
+// Helper. Bootstrap info box with single segment and fragment run tables.
+func testAbst(live bool, timescale uint32, current uint64, asrt, afrt []byte) []byte {
+	var flags byte
+	if live {
+		flags = 0x20
+	}
+	data := append(u32s(0, 1), flags)
+	data = append(data, u32s(timescale)...)
+	data = append(data, u64(current)...)
+	data = append(data, u64(0)...)
+	data = append(data, 0, 0, 0, 0, 0) // movie id, servers, qualities, DRM, metadata
+	data = append(data, 1)
+	data = append(data, asrt...)
+	data = append(data, 1)
+	data = append(data, afrt...)
+	return box("abst", data)
+}
+
+func TestParseBootstrap(t *testing.T) {
+	asrt := testAsrt(SegmentRun{1, 100})
+	afrt := testAfrt(1000, FragmentRun{FirstFragment: 1, Duration: 4000})
+	valid := testAbst(true, 1000, 40000, asrt, afrt)
+
+	tests := []struct {
+		name string
+		data []byte
+		err  bool
+	}{
+		{"valid", valid, false},
+		{"truncated", valid[:len(valid)-6], true},
+		{"truncated header", valid[:20], true},
+		{"no abst", box("moov"), true},
+		{"empty run tables", testAbst(false, 1000, 0, testAsrt(), testAfrt(1000)), true},
+	}
+	for _, test := range tests {
+		_, err := ParseBootstrap(test.data)
+		if (err != nil) != test.err {
+			t.Errorf("%s: error %v", test.name, err)
+		}
+	}
+	b, _ := ParseBootstrap(valid)
+	if !b.Live || b.Timescale != 1000 || b.MediaTime() != 40 || len(b.Segments) != 1 || b.FragmentTimescale != 1000 || len(b.Fragments) != 1 || b.Fragments[0].Duration != 4000 {
+		t.Errorf("bootstrap parsed as %+v", b)
+	}
+}
+
+func TestLastFragment(t *testing.T) {
+	tests := []struct {
+		name              string
+		bootstrap         Bootstrap
+		segment, fragment uint32
+		duration          float64
+	}{
+		{
+			name:      "live",
+			bootstrap: Bootstrap{Timescale: 1000, CurrentMediaTime: 40000, Segments: []SegmentRun{{1, 100}}, FragmentTimescale: 1000, Fragments: []FragmentRun{{FirstFragment: 1, Duration: 4000}}},
+			segment:   1, fragment: 10, duration: 4,
+		},
+		{
+			name:      "timescales differ",
+			bootstrap: Bootstrap{Timescale: 1000, CurrentMediaTime: 40000, Segments: []SegmentRun{{1, 100}}, FragmentTimescale: 90000, Fragments: []FragmentRun{{FirstFragment: 1, Duration: 360000}}},
+			segment:   1, fragment: 10, duration: 4,
+		},
+		{
+			name:      "discontinuity entry skipped",
+			bootstrap: Bootstrap{Timescale: 1000, CurrentMediaTime: 20000, Segments: []SegmentRun{{1, 100}}, FragmentTimescale: 1000, Fragments: []FragmentRun{{FirstFragment: 5, FirstTimestamp: 8000, Duration: 2000}, {FirstFragment: 7, Discontinuity: 1}}},
+			segment:   1, fragment: 10, duration: 2,
+		},
+		{
+			name:      "several segments",
+			bootstrap: Bootstrap{Timescale: 1000, CurrentMediaTime: 40000, Segments: []SegmentRun{{1, 3}, {2, 5}}, FragmentTimescale: 1000, Fragments: []FragmentRun{{FirstFragment: 1, Duration: 4000}}},
+			segment:   3, fragment: 10, duration: 4,
+		},
+		{
+			name:      "media time before the run",
+			bootstrap: Bootstrap{Timescale: 1000, CurrentMediaTime: 1000, Segments: []SegmentRun{{1, 100}}, FragmentTimescale: 1000, Fragments: []FragmentRun{{FirstFragment: 3, FirstTimestamp: 8000, Duration: 4000}}},
+			segment:   1, fragment: 3, duration: 4,
+		},
+		{
+			name:      "no durations",
+			bootstrap: Bootstrap{Fragments: []FragmentRun{{FirstFragment: 1}}},
+		},
+	}
+	for _, test := range tests {
+		segment, fragment, duration := test.bootstrap.LastFragment()
+		if segment != test.segment || fragment != test.fragment || duration != test.duration {
+			t.Errorf("%s: got segment %d, fragment %d, duration %f", test.name, segment, fragment, duration)
+		}
+	}
+}
+
+// Helper. FLV tag with the timestamp (ms) followed by the previous tag size.
+func flvTag(tagType byte, timestamp uint32, size int) []byte {
+	tag := []byte{tagType, byte(size >> 16), byte(size >> 8), byte(size), byte(timestamp >> 16), byte(timestamp >> 8), byte(timestamp), byte(timestamp >> 24), 0, 0, 0}
+	tag = append(tag, make([]byte, size)...)
+	return append(tag, u32s(uint32(11+size))...)
+}
+
+func TestInspectF4F(t *testing.T) {
+	var tags []byte
+	for ts := uint32(0); ts <= 3960; ts += 40 {
+		tags = append(tags, flvTag(9, ts, 10)...)
+		tags = append(tags, flvTag(8, ts, 5)...)
+	}
+	fragment := append(box("afra", u32s(0)), box("abst")...)
+	fragment = append(fragment, box("moof")...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		declared float64
+		broken   bool
+		duration float64
+		bad      bool
+	}{
+		{name: "valid", data: append(fragment, box("mdat", tags)...), declared: 4, duration: 3.96},
+		{name: "audio only", data: box("mdat", flvTag(18, 0, 20), flvTag(8, 0, 5), flvTag(8, 2000, 5)), declared: 2, duration: 2},
+		{name: "duration mismatch", data: box("mdat", tags), declared: 10, duration: 3.96, bad: true},
+		{name: "truncated tag", data: box("mdat", tags[:len(tags)-3]), broken: true, duration: 0},
+		{name: "unknown tag", data: box("mdat", flvTag(7, 0, 5)), broken: true},
+		{name: "truncated mdat", data: append(fragment, box("mdat", tags)[:100]...), broken: true},
+		{name: "no mdat", data: fragment, broken: true},
+	}
+	for _, test := range tests {
+		meta := InspectF4F(test.data, test.declared)
+		if meta.Broken != test.broken || meta.BadDuration != test.bad || math.Abs(meta.Duration-test.duration) > 0.001 {
+			t.Errorf("%s: got broken %v, bad duration %v, duration %f (%v)", test.name, meta.Broken, meta.BadDuration, meta.Duration, meta.Problems)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"strings"
+)
+
+var ErrTruncatedBox = errors.New("truncated box")
+
+// ISO BMFF box (atom).
+type Box struct {
+	Type   string
+	Offset int64 // offset of the box header in the parent data
+	Size   int64 // including header
+	Data   []byte
+}
+
+// Parse sequence of boxes. Boxes parsed before the error returned too.
+func ReadBoxes(data []byte) ([]Box, error) {
+	var boxes []Box
+	var offset int64
+
+	for offset < int64(len(data)) {
+		size, header, err := BoxHeader(data[offset:])
+		if err != nil {
+			return boxes, err
+		}
+		if size == 0 { // box extends to the end of data
+			size = int64(len(data)) - offset
+		}
+		if size < header || offset+size > int64(len(data)) {
+			return boxes, ErrTruncatedBox
+		}
+		boxes = append(boxes, Box{Type: string(data[offset+4 : offset+8]), Offset: offset, Size: size, Data: data[offset+header : offset+size]})
+		offset += size
+	}
+	return boxes, nil
+}
+
+// Get size of the box and size of its header from the beginning of the box.
+func BoxHeader(data []byte) (size, header int64, err error) {
+	if len(data) < 8 {
+		return 0, 0, ErrTruncatedBox
+	}
+	size, header = int64(binary.BigEndian.Uint32(data)), 8
+	if size == 1 { // 64-bit largesize
+		if len(data) < 16 {
+			return 0, 0, ErrTruncatedBox
+		}
+		size, header = int64(binary.BigEndian.Uint64(data[8:])), 16
+	}
+	return size, header, nil
+}
+
+// Helper. Find the first child box by path of types.
+func findBox(data []byte, path ...string) []byte {
+	boxes, _ := ReadBoxes(data)
+	for _, b := range boxes {
+		if b.Type == path[0] {
+			if len(path) == 1 {
+				return b.Data
+			}
+			return findBox(b.Data, path[1:]...)
+		}
+	}
+	return nil
+}
+
+// Inspect fMP4 initialization segment: brands and tracks from `ftyp` and `moov`.
+func InspectInit(data []byte) (*MetaInit, *MetaMedia) {
+	init := new(MetaInit)
+	meta := &MetaMedia{Container: "fmp4 init"}
+
+	boxes, err := ReadBoxes(data)
+	if err != nil {
+		meta.Broken = true
+		meta.Problems = append(meta.Problems, fmt.Sprintf("top level boxes: %s", err))
+	}
+	var moov []byte
+	for _, b := range boxes {
+		switch b.Type {
+		case "ftyp":
+			init.Brands = ParseBrands(b.Data)
+		case "moov":
+			moov = b.Data
+		}
+	}
+	if len(init.Brands) == 0 {
+		meta.Problems = append(meta.Problems, "ftyp not found")
+	}
+	if moov == nil {
+		meta.Broken = true
+		meta.Problems = append(meta.Problems, "moov not found")
+		return init, meta
+	}
+	if _, err := ReadBoxes(moov); err != nil {
+		meta.Broken = true
+		meta.Problems = append(meta.Problems, fmt.Sprintf("moov: %s", err))
+	}
+	init.Tracks = ParseTracks(moov)
+	for _, track := range init.Tracks {
+		meta.Streams = append(meta.Streams, fmt.Sprintf("%s %s (track %d)", track.Handler, track.Codec, track.Id))
+	}
+	if len(init.Tracks) == 0 {
+		meta.Broken = true
+		meta.Problems = append(meta.Problems, "no tracks in moov")
+	}
+	return init, meta
+}
+
+// Get major and compatible brands from the payload of `ftyp` box.
+func ParseBrands(ftyp []byte) []string {
+	var brands []string
+
+	if len(ftyp) >= 8 {
+		brands = append(brands, string(ftyp[0:4]))
+		for i := 8; i+4 <= len(ftyp); i += 4 {
+			brands = append(brands, string(ftyp[i:i+4]))
+		}
+	}
+	return brands
+}
+
+// Get track properties from the payload of `moov` box.
+func ParseTracks(moov []byte) []MetaTrack {
+	var tracks []MetaTrack
+
+	boxes, _ := ReadBoxes(moov)
+	for _, b := range boxes {
+		if b.Type != "trak" {
+			continue
+		}
+		var track MetaTrack
+		if tkhd := findBox(b.Data, "tkhd"); len(tkhd) >= 24 {
+			if tkhd[0] == 1 {
+				track.Id = binary.BigEndian.Uint32(tkhd[20:])
+			} else {
+				track.Id = binary.BigEndian.Uint32(tkhd[12:])
+			}
+		}
+		if mdhd := findBox(b.Data, "mdia", "mdhd"); len(mdhd) >= 24 {
+			if mdhd[0] == 1 {
+				if len(mdhd) >= 32 {
+					track.Timescale = binary.BigEndian.Uint32(mdhd[20:])
+					track.Duration = binary.BigEndian.Uint64(mdhd[24:])
+				}
+			} else {
+				track.Timescale = binary.BigEndian.Uint32(mdhd[12:])
+				track.Duration = uint64(binary.BigEndian.Uint32(mdhd[16:]))
+			}
+		}
+		if hdlr := findBox(b.Data, "mdia", "hdlr"); len(hdlr) >= 12 {
+			track.Handler = string(hdlr[8:12])
+		}
+		if stsd := findBox(b.Data, "mdia", "minf", "stbl", "stsd"); len(stsd) >= 16 {
+			track.Codec = strings.TrimSpace(string(stsd[12:16])) // first sample entry
+			if track.Codec == "encv" || track.Codec == "enca" {  // original format in sinf/frma
+				if entries, _ := ReadBoxes(stsd[8:]); len(entries) > 0 && len(entries[0].Data) > 28 {
+					skip := 28 // audio sample entry
+					if track.Codec == "encv" {
+						skip = 78 // visual sample entry
+					}
+					if len(entries[0].Data) > skip {
+						if frma := findBox(entries[0].Data[skip:], "sinf", "frma"); len(frma) >= 4 {
+							track.Codec = fmt.Sprintf("%s/%s", track.Codec, frma[0:4])
+						}
+					}
+				}
+			}
+		}
+		tracks = append(tracks, track)
+	}
+	// defaults for fragments
+	if mvex := findBox(moov, "mvex"); mvex != nil {
+		boxes, _ := ReadBoxes(mvex)
+		for _, b := range boxes {
+			if b.Type == "trex" && len(b.Data) >= 20 {
+				id := binary.BigEndian.Uint32(b.Data[4:])
+				for i := range tracks {
+					if tracks[i].Id == id {
+						tracks[i].DefaultDuration = binary.BigEndian.Uint32(b.Data[12:])
+					}
+				}
+			}
+		}
+	}
+	return tracks
+}
+
+// Inspect fMP4 media segment: box structure, `moof` fragments and samples duration.
+// Tracks timescales taken from the initialization segment. `declared` is the segment duration
+// from the playlist (sec), zero if unknown.
+func InspectFMP4(data []byte, init *MetaInit, declared float64) *MetaMedia {
+	var mdatFound bool
+
+	meta := &MetaMedia{Container: "fmp4", Declared: declared, DecodeTime: -1}
+	boxes, err := ReadBoxes(data)
+	if err != nil {
+		meta.Broken = true
+		meta.Problems = append(meta.Problems, fmt.Sprintf("top level boxes: %s", err))
+	}
+	durations := make(map[uint32]uint64) // track id: samples duration in track timescale
+	decodeTimes := make(map[uint32]uint64)
+	for _, b := range boxes {
+		switch b.Type {
+		case "moof":
+			if err := parseMoof(b.Data, init, durations, decodeTimes); err != nil {
+				meta.Broken = true
+				meta.Problems = append(meta.Problems, fmt.Sprintf("moof: %s", err))
+			}
+		case "mdat":
+			mdatFound = true
+		}
+	}
+	if len(durations) == 0 {
+		meta.Broken = true
+		meta.Problems = append(meta.Problems, "moof not found")
+	}
+	if !mdatFound {
+		meta.Broken = true
+		meta.Problems = append(meta.Problems, "mdat not found")
+	}
+	if init == nil {
+		meta.Problems = append(meta.Problems, "no initialization segment, durations unknown")
+		return meta
+	}
+	// Durations measured by the video track or by the first track found.
+	var measured *MetaTrack
+	for i, track := range init.Tracks {
+		if _, ok := durations[track.Id]; ok && track.Timescale > 0 && (measured == nil || track.Handler == "vide" && measured.Handler != "vide") {
+			measured = &init.Tracks[i]
+		}
+		if _, ok := durations[track.Id]; ok {
+			meta.Streams = append(meta.Streams, fmt.Sprintf("%s %s (track %d)", track.Handler, track.Codec, track.Id))
+		}
+	}
+	if measured != nil {
+		meta.Duration = float64(durations[measured.Id]) / float64(measured.Timescale)
+		if decodeTime, ok := decodeTimes[measured.Id]; ok {
+			meta.DecodeTime = float64(decodeTime) / float64(measured.Timescale)
+		}
+	}
+	checkDuration(meta)
+	return meta
+}
+
+// Helper. Sum samples duration and get base media decode time for each track fragment.
+func parseMoof(moof []byte, init *MetaInit, durations, decodeTimes map[uint32]uint64) error {
+	boxes, err := ReadBoxes(moof)
+	if err != nil {
+		return err
+	}
+	for _, b := range boxes {
+		if b.Type != "traf" {
+			continue
+		}
+		trafs, err := ReadBoxes(b.Data)
+		if err != nil {
+			return fmt.Errorf("traf: %s", err)
+		}
+		var id, defaultDuration uint32
+		var decodeTime uint64
+		var hasDecodeTime bool
+		for _, t := range trafs {
+			switch t.Type {
+			case "tfhd":
+				if len(t.Data) < 8 {
+					return fmt.Errorf("tfhd: %s", ErrTruncatedBox)
+				}
+				flags := binary.BigEndian.Uint32(t.Data) & 0xffffff
+				id = binary.BigEndian.Uint32(t.Data[4:])
+				offset := 8
+				if flags&0x01 != 0 { // base data offset
+					offset += 8
+				}
+				if flags&0x02 != 0 { // sample description index
+					offset += 4
+				}
+				if flags&0x08 != 0 { // default sample duration
+					if len(t.Data) < offset+4 {
+						return fmt.Errorf("tfhd: %s", ErrTruncatedBox)
+					}
+					defaultDuration = binary.BigEndian.Uint32(t.Data[offset:])
+				}
+			case "tfdt":
+				if len(t.Data) >= 12 && t.Data[0] == 1 {
+					decodeTime, hasDecodeTime = binary.BigEndian.Uint64(t.Data[4:]), true
+				} else if len(t.Data) >= 8 {
+					decodeTime, hasDecodeTime = uint64(binary.BigEndian.Uint32(t.Data[4:])), true
+				} else {
+					return fmt.Errorf("tfdt: %s", ErrTruncatedBox)
+				}
+			}
+		}
+		if defaultDuration == 0 && init != nil {
+			for _, track := range init.Tracks {
+				if track.Id == id {
+					defaultDuration = track.DefaultDuration
+				}
+			}
+		}
+		if _, ok := durations[id]; !ok { // first fragment of the track in the segment
+			durations[id] = 0
+			if hasDecodeTime {
+				decodeTimes[id] = decodeTime
+			}
+		}
+		for _, t := range trafs {
+			if t.Type != "trun" {
+				continue
+			}
+			duration, err := trunDuration(t.Data, defaultDuration)
+			if err != nil {
+				return fmt.Errorf("trun: %s", err)
+			}
+			durations[id] += duration
+		}
+	}
+	return nil
+}
+
+// Helper. Sum duration of samples in the track run.
+func trunDuration(trun []byte, defaultDuration uint32) (uint64, error) {
+	var duration uint64
+
+	if len(trun) < 8 {
+		return 0, ErrTruncatedBox
+	}
+	flags := binary.BigEndian.Uint32(trun) & 0xffffff
+	count := int(binary.BigEndian.Uint32(trun[4:]))
+	offset := 8
+	if flags&0x01 != 0 { // data offset
+		offset += 4
+	}
+	if flags&0x04 != 0 { // first sample flags
+		offset += 4
+	}
+	size := 0
+	for _, f := range []uint32{0x100, 0x200, 0x400, 0x800} { // duration, size, flags, composition offset
+		if flags&f != 0 {
+			size += 4
+		}
+	}
+	if offset+count*size > len(trun) {
+		return 0, ErrTruncatedBox
+	}
+	if flags&0x100 == 0 {
+		return uint64(count) * uint64(defaultDuration), nil
+	}
+	for i := 0; i < count; i++ {
+		duration += uint64(binary.BigEndian.Uint32(trun[offset+i*size:]))
+	}
+	return duration, nil
+}
+
+// Subsegment reference of `sidx` box.
+type SidxReference struct {
+	Offset   int64   // offset of the subsegment in the file
+	Size     int64   // size of the subsegment
+	Duration float64 // sec
+}
+
+// Parse segment index (`sidx` box) from the data started at `offset` of the file.
+// Offsets of the subsegments counted from the first byte after the box.
+func ParseSidx(data []byte, offset int64) ([]SidxReference, error) {
+	var refs []SidxReference
+
+	boxes, err := ReadBoxes(data)
+	for _, b := range boxes {
+		if b.Type != "sidx" {
+			continue
+		}
+		r := &fieldReader{data: b.Data}
+		version := r.u8()
+		r.next(3) // flags
+		r.u32()   // reference ID
+		timescale := r.u32()
+		var first uint64
+		if version == 0 {
+			r.u32() // earliest presentation time
+			first = uint64(r.u32())
+		} else {
+			r.u64()
+			first = r.u64()
+		}
+		r.next(2) // reserved
+		count := binary.BigEndian.Uint16(r.next(2))
+		pos := offset + b.Offset + b.Size + int64(first)
+		for i := uint16(0); i < count && r.err == nil; i++ {
+			size := int64(r.u32() & 0x7fffffff) // reference type bit dropped
+			duration := r.u32()
+			r.u32() // SAP
+			ref := SidxReference{Offset: pos, Size: size}
+			if timescale > 0 {
+				ref.Duration = float64(duration) / float64(timescale)
+			}
+			refs = append(refs, ref)
+			pos += size
+		}
+		return refs, r.err
+	}
+	if err == nil {
+		err = errors.New("sidx not found")
+	}
+	return nil, err
+}
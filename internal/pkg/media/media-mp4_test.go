@@ -0,0 +1,173 @@
+package media
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+)
+
+// Helper. Box with 32-bit size header.
+func box(typ string, payload ...[]byte) []byte {
+	var data []byte
+	for _, p := range payload {
+		data = append(data, p...)
+	}
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(8+len(data)))
+	copy(header[4:], typ)
+	return append(header, data...)
+}
+
+// Helper. Big endian 32-bit fields.
+func u32s(vals ...uint32) []byte {
+	data := make([]byte, 4*len(vals))
+	for i, v := range vals {
+		binary.BigEndian.PutUint32(data[4*i:], v)
+	}
+	return data
+}
+
+// Helper. Initialization segment with a single track of the handler and timescale.
+func testInit(id uint32, handler, codec string, timescale, defaultDuration uint32) []byte {
+	tkhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(tkhd[12:], id)
+	mdhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(mdhd[12:], timescale)
+	hdlr := append(make([]byte, 8), handler...)
+	hdlr = append(hdlr, make([]byte, 12)...)
+	stsd := append(u32s(0, 1), box(codec, make([]byte, 78))...)
+	trex := u32s(0, id, 1, defaultDuration, 0, 0)
+	return append(box("ftyp", []byte("iso6"), u32s(0), []byte("iso6cmfc")),
+		box("moov",
+			box("trak", box("tkhd", tkhd), box("mdia", box("mdhd", mdhd), box("hdlr", hdlr), box("minf", box("stbl", box("stsd", stsd))))),
+			box("mvex", box("trex", trex)))...)
+}
+
+// Helper. Media segment with one fragment of the track: samples durations or their number
+// when durations come from defaults.
+func testFragment(id uint32, decodeTime uint32, count uint32, durations ...uint32) []byte {
+	trun := u32s(0, count)
+	if len(durations) > 0 {
+		trun = u32s(0x100, count)
+		trun = append(trun, u32s(durations...)...)
+	}
+	return append(box("moof", box("mfhd", u32s(0, 1)), box("traf", box("tfhd", u32s(0, id)), box("tfdt", u32s(0, decodeTime)), box("trun", trun))),
+		box("mdat", make([]byte, 16))...)
+}
+
+func TestReadBoxes(t *testing.T) {
+	large := append(u32s(1), []byte("free")...)
+	large = append(large, 0, 0, 0, 0, 0, 0, 0, 20)
+	large = append(large, 1, 2, 3, 4)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		types []string
+		err   bool
+	}{
+		{"empty", nil, nil, false},
+		{"two boxes", append(box("styp", []byte("msdh")), box("mdat", []byte{1})...), []string{"styp", "mdat"}, false},
+		{"truncated header", append(box("styp"), 0, 0, 0), []string{"styp"}, true},
+		{"size beyond data", box("mdat", []byte{1, 2, 3})[:9], nil, true},
+		{"size less than header", u32s(4, 0x6d646174), nil, true},
+		{"size zero up to the end", append(u32s(0), []byte("mdat1234")...), []string{"mdat"}, false},
+		{"largesize", large, []string{"free"}, false},
+		{"truncated largesize", large[:12], nil, true},
+	}
+	for _, test := range tests {
+		boxes, err := ReadBoxes(test.data)
+		if (err != nil) != test.err || len(boxes) != len(test.types) {
+			t.Errorf("%s: got %d boxes, error %v", test.name, len(boxes), err)
+			continue
+		}
+		for i, b := range boxes {
+			if b.Type != test.types[i] {
+				t.Errorf("%s: box %d is %s, expected %s", test.name, i, b.Type, test.types[i])
+			}
+		}
+	}
+}
+
+func TestInspectInit(t *testing.T) {
+	data := testInit(1, "vide", "avc1", 90000, 3000)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		broken bool
+		tracks int
+	}{
+		{"valid", data, false, 1},
+		{"truncated moov", data[:len(data)-10], true, 0},
+		{"no moov", box("ftyp", []byte("iso6"), u32s(0)), true, 0},
+		{"empty", nil, true, 0},
+	}
+	for _, test := range tests {
+		init, meta := InspectInit(test.data)
+		if meta.Broken != test.broken || len(init.Tracks) != test.tracks {
+			t.Errorf("%s: got broken %v, %d tracks (%v)", test.name, meta.Broken, len(init.Tracks), meta.Problems)
+		}
+	}
+	init, _ := InspectInit(data)
+	track := init.Tracks[0]
+	if track.Id != 1 || track.Handler != "vide" || track.Codec != "avc1" || track.Timescale != 90000 || track.DefaultDuration != 3000 {
+		t.Errorf("track parsed as %+v", track)
+	}
+	if len(init.Brands) != 3 || init.Brands[0] != "iso6" || init.Brands[2] != "cmfc" {
+		t.Errorf("brands parsed as %v", init.Brands)
+	}
+}
+
+func TestInspectFMP4(t *testing.T) {
+	init, _ := InspectInit(testInit(1, "vide", "avc1", 90000, 3000))
+	fragment := testFragment(1, 180000, 3, 3000, 3000, 6000)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		init       *MetaInit
+		declared   float64
+		broken     bool
+		duration   float64
+		decodeTime float64
+		bad        bool
+	}{
+		{name: "samples durations", data: fragment, init: init, declared: 0.133, duration: 12000. / 90000, decodeTime: 2},
+		{name: "default durations", data: testFragment(1, 0, 30), init: init, declared: 1, duration: 1, decodeTime: 0},
+		{name: "duration mismatch", data: fragment, init: init, declared: 6, duration: 12000. / 90000, decodeTime: 2, bad: true},
+		{name: "no init", data: fragment, declared: 1, decodeTime: -1},
+		{name: "truncated mdat", data: fragment[:len(fragment)-4], init: init, broken: true, duration: 12000. / 90000, decodeTime: 2},
+		{name: "truncated trun", data: append(box("moof", box("traf", box("tfhd", u32s(0, 1)), box("trun", u32s(0x100, 5, 3000)))), box("mdat")...), init: init, broken: true, decodeTime: -1},
+		{name: "truncated tfhd", data: append(box("moof", box("traf", box("tfhd", u32s(0x08, 1)))), box("mdat")...), init: init, broken: true, decodeTime: -1},
+		{name: "no moof", data: box("mdat", []byte{1}), init: init, broken: true, decodeTime: -1},
+	}
+	for _, test := range tests {
+		meta := InspectFMP4(test.data, test.init, test.declared)
+		if meta.Broken != test.broken || meta.BadDuration != test.bad || math.Abs(meta.Duration-test.duration) > 0.0001 || meta.DecodeTime != test.decodeTime {
+			t.Errorf("%s: got broken %v, bad duration %v, duration %f, decode time %f (%v)", test.name, meta.Broken, meta.BadDuration, meta.Duration, meta.DecodeTime, meta.Problems)
+		}
+	}
+}
+
+func TestParseSidx(t *testing.T) {
+	sidx := box("sidx", u32s(0, 1, 1000, 0, 100), []byte{0, 0, 0, 2}, u32s(500, 2000, 0x90000000, 700, 4000, 0x90000000))
+
+	refs, err := ParseSidx(sidx, 1000)
+	if err != nil || len(refs) != 2 {
+		t.Fatalf("got %v, %v", refs, err)
+	}
+	start := int64(1000 + len(sidx) + 100)
+	if refs[0].Offset != start || refs[0].Size != 500 || refs[0].Duration != 2 || refs[1].Offset != start+500 || refs[1].Duration != 4 {
+		t.Errorf("references parsed as %+v", refs)
+	}
+	truncated := box("sidx", u32s(0, 1, 1000, 0, 100), []byte{0, 0, 0, 2}, u32s(500, 2000, 0))
+	if _, err := ParseSidx(truncated, 0); err == nil {
+		t.Error("truncated references not reported")
+	}
+	if _, err := ParseSidx(box("moof"), 0); err == nil {
+		t.Error("missing sidx not reported")
+	}
+}
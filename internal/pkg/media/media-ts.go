@@ -0,0 +1,277 @@
+// Native inspectors of media segments.
+package media
+
+import (
+	"fmt"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"math"
+	"sort"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	tsNullPID    = 0x1fff
+	tsClock      = 90000. // PTS and PCR base clock (Hz)
+	tsWrap       = 1 << 33
+
+	maxTimestampJump = 1. // sec, bigger gaps between timestamps reported as discontinuities
+
+	durationTolerance      = 0.5 // sec, allowed difference between real and declared segment duration
+	durationToleranceRatio = 0.1 // or part of the declared duration if it is bigger
+)
+
+// Elementary stream types from PMT.
+var tsStreamTypes = map[byte]string{
+	0x01: "MPEG-1 video",
+	0x02: "MPEG-2 video",
+	0x03: "MPEG-1 audio",
+	0x04: "MPEG-2 audio",
+	0x06: "private data",
+	0x0f: "AAC",
+	0x11: "AAC LATM",
+	0x15: "ID3 metadata",
+	0x1b: "H.264",
+	0x24: "HEVC",
+	0x81: "AC-3",
+	0x87: "E-AC-3",
+	0xc1: "AC-3 (SAMPLE-AES)",
+	0xc2: "E-AC-3 (SAMPLE-AES)",
+	0xcf: "AAC (SAMPLE-AES)",
+	0xdb: "H.264 (SAMPLE-AES)",
+}
+
+// state of the single PID while inspecting
+type tsPID struct {
+	cc       int     // last continuity counter (-1 before first packet)
+	lastPTS  float64 // sec, counted from the first PTS of the segment across 2^33 wraps
+	hasPTS   bool
+	wraps    float64 // sec, added to PTS after wraps
+	minPTS   float64
+	maxPTS   float64
+	countPTS int
+	video    bool
+}
+
+// Inspect MPEG-TS segment: packets sync, PAT/PMT, continuity counters and timestamps.
+// `declared` is the segment duration from the playlist (sec), zero if unknown.
+func InspectTS(data []byte, declared float64) *MetaMedia {
+	var (
+		pmtPID          = -1
+		pcrPID          = -1
+		lastPCR         float64
+		hasPCR          bool
+		patFound        bool
+		pmtFound        bool
+		streamTypes     = make(map[int]byte)
+		pids            = make(map[int]*tsPID)
+		truncatedPacket bool
+	)
+
+	meta := &MetaMedia{Container: "ts", Declared: declared}
+	if len(data)%tsPacketSize != 0 {
+		truncatedPacket = true
+		meta.Problems = append(meta.Problems, fmt.Sprintf("segment size %d is not multiple of %d", len(data), tsPacketSize))
+	}
+	for offset := 0; offset+tsPacketSize <= len(data); offset += tsPacketSize {
+		pkt := data[offset : offset+tsPacketSize]
+		if pkt[0] != tsSyncByte {
+			meta.SyncErrors++
+			continue
+		}
+		pid := int(pkt[1]&0x1f)<<8 | int(pkt[2])
+		if pid == tsNullPID {
+			continue
+		}
+		pusi := pkt[1]&0x40 != 0
+		afc := (pkt[3] >> 4) & 0x3
+		cc := int(pkt[3] & 0xf)
+		payload := 4
+		discontinuity := false
+
+		if afc&0x2 != 0 { // adaptation field
+			aflen := int(pkt[4])
+			payload = 5 + aflen
+			if aflen > 0 && payload <= tsPacketSize {
+				flags := pkt[5]
+				discontinuity = flags&0x80 != 0
+				if flags&0x10 != 0 && aflen >= 7 && pid == pcrPID { // PCR
+					pcr := float64(uint64(pkt[6])<<25|uint64(pkt[7])<<17|uint64(pkt[8])<<9|uint64(pkt[9])<<1|uint64(pkt[10])>>7) / tsClock
+					if hasPCR && !discontinuity && timestampJump(lastPCR, pcr) {
+						meta.PCRJumps++
+					}
+					lastPCR, hasPCR = pcr, true
+				}
+			}
+		}
+
+		state, ok := pids[pid]
+		if !ok {
+			state = &tsPID{cc: -1}
+			pids[pid] = state
+		}
+		if afc&0x1 != 0 { // continuity counter incremented only for packets with payload
+			if state.cc >= 0 && !discontinuity && cc != (state.cc+1)%16 && cc != state.cc {
+				meta.CCErrors++
+			}
+			state.cc = cc
+		}
+		if afc&0x1 == 0 || payload >= tsPacketSize {
+			continue
+		}
+
+		switch {
+		case pid == 0 && pusi:
+			if section := psiSection(pkt[payload:], 0x00); section != nil {
+				patFound = true
+				// program loop follows the header and precedes CRC32
+				for i := 8; i+4 <= len(section)-4; i += 4 {
+					program := int(section[i])<<8 | int(section[i+1])
+					if program != 0 { // zero is the network PID
+						pmtPID = int(section[i+2]&0x1f)<<8 | int(section[i+3])
+						break
+					}
+				}
+			}
+		case pid == pmtPID && pusi:
+			if section := psiSection(pkt[payload:], 0x02); section != nil && len(section) >= 12 {
+				pmtFound = true
+				pcrPID = int(section[8]&0x1f)<<8 | int(section[9])
+				infolen := int(section[10]&0x0f)<<8 | int(section[11])
+				for i := 12 + infolen; i+5 <= len(section)-4; {
+					estype := section[i]
+					espid := int(section[i+1]&0x1f)<<8 | int(section[i+2])
+					streamTypes[espid] = estype
+					i += 5 + (int(section[i+3]&0x0f)<<8 | int(section[i+4]))
+				}
+			}
+		case pusi:
+			if estype, ok := streamTypes[pid]; ok {
+				if pts, ok := pesPTS(pkt[payload:]); ok {
+					state.video = isVideo(estype)
+					pts += state.wraps
+					if state.hasPTS && pts-state.lastPTS < -tsWrap/tsClock/2 {
+						state.wraps += tsWrap / tsClock
+						pts += tsWrap / tsClock
+					}
+					if state.hasPTS && !discontinuity && timestampJump(state.lastPTS, pts) {
+						meta.PTSJumps++
+					}
+					if !state.hasPTS || pts < state.minPTS {
+						state.minPTS = pts
+					}
+					if !state.hasPTS || pts > state.maxPTS {
+						state.maxPTS = pts
+					}
+					state.lastPTS, state.hasPTS = pts, true
+					state.countPTS++
+				}
+			}
+		}
+	}
+
+	var espids []int
+	for pid := range streamTypes {
+		espids = append(espids, pid)
+	}
+	sort.Ints(espids)
+	for _, pid := range espids {
+		name, ok := tsStreamTypes[streamTypes[pid]]
+		if !ok {
+			name = fmt.Sprintf("type 0x%02x", streamTypes[pid])
+		}
+		meta.Streams = append(meta.Streams, fmt.Sprintf("%s (PID %d)", name, pid))
+	}
+
+	// Duration measured by PTS of the video stream or by the first stream with timestamps.
+	var measured *tsPID
+	for _, pid := range espids {
+		if state, ok := pids[pid]; ok && state.countPTS > 1 && (measured == nil || state.video && !measured.video) {
+			measured = state
+		}
+	}
+	if measured != nil {
+		span := measured.maxPTS - measured.minPTS
+		meta.Duration = span + span/float64(measured.countPTS-1) // plus one frame
+	}
+
+	if meta.SyncErrors > 0 {
+		meta.Problems = append(meta.Problems, fmt.Sprintf("%d packets without sync byte", meta.SyncErrors))
+	}
+	if !patFound {
+		meta.Problems = append(meta.Problems, "PAT not found")
+	}
+	if !pmtFound {
+		meta.Problems = append(meta.Problems, "PMT not found")
+	}
+	meta.Broken = truncatedPacket || meta.SyncErrors > 0 || !patFound || !pmtFound
+	if meta.CCErrors > 0 {
+		meta.Problems = append(meta.Problems, fmt.Sprintf("%d continuity counter errors", meta.CCErrors))
+	}
+	if meta.PCRJumps > 0 {
+		meta.Problems = append(meta.Problems, fmt.Sprintf("%d PCR discontinuities", meta.PCRJumps))
+	}
+	if meta.PTSJumps > 0 {
+		meta.Problems = append(meta.Problems, fmt.Sprintf("%d PTS discontinuities", meta.PTSJumps))
+	}
+	checkDuration(meta)
+	return meta
+}
+
+// Helper. Get PSI section with expected table id from the packet payload.
+func psiSection(payload []byte, tableId byte) []byte {
+	if len(payload) < 1 {
+		return nil
+	}
+	start := 1 + int(payload[0]) // pointer field
+	if start+3 > len(payload) || payload[start] != tableId {
+		return nil
+	}
+	length := int(payload[start+1]&0x0f)<<8 | int(payload[start+2])
+	end := start + 3 + length
+	if end > len(payload) || length < 9 {
+		return nil
+	}
+	return payload[start:end]
+}
+
+// Helper. Get PTS (sec) from the PES header.
+func pesPTS(payload []byte) (float64, bool) {
+	if len(payload) < 14 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return 0, false
+	}
+	if payload[7]&0x80 == 0 { // PTS_DTS_flags
+		return 0, false
+	}
+	p := payload[9:14]
+	pts := uint64(p[0]>>1&0x07)<<30 | uint64(p[1])<<22 | uint64(p[2]>>1)<<15 | uint64(p[3])<<7 | uint64(p[4]>>1)
+	return float64(pts) / tsClock, true
+}
+
+// Helper. Detect gap between timestamps bigger than allowed. Timestamps wrap at 2^33.
+func timestampJump(prev, next float64) bool {
+	delta := next - prev
+	if delta < -tsWrap/tsClock/2 {
+		delta += tsWrap / tsClock
+	}
+	return math.Abs(delta) > maxTimestampJump
+}
+
+func isVideo(estype byte) bool {
+	switch estype {
+	case 0x01, 0x02, 0x1b, 0x24, 0xdb:
+		return true
+	}
+	return false
+}
+
+// Helper. Compare measured segment duration with the declared one.
+func checkDuration(meta *MetaMedia) {
+	if meta.Declared <= 0 || meta.Duration <= 0 {
+		return
+	}
+	if math.Abs(meta.Duration-meta.Declared) > math.Max(durationTolerance, meta.Declared*durationToleranceRatio) {
+		meta.BadDuration = true
+		meta.Problems = append(meta.Problems, fmt.Sprintf("real duration %.3fs differs from declared %.3fs", meta.Duration, meta.Declared))
+	}
+}
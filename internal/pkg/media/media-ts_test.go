@@ -0,0 +1,118 @@
+package media
+
+import (
+	"math"
+	"testing"
+)
+
+const (
+	testVideoPID = 0x100
+	testPMTPID   = 0x1000
+)
+
+// Helper. TS packet with the payload padded by stuffing bytes.
+func tsPacket(pid int, pusi bool, cc int, payload []byte) []byte {
+	pkt := make([]byte, tsPacketSize)
+	pkt[0] = tsSyncByte
+	pkt[1] = byte(pid >> 8 & 0x1f)
+	if pusi {
+		pkt[1] |= 0x40
+	}
+	pkt[2] = byte(pid)
+	pkt[3] = 0x10 | byte(cc&0xf) // payload only
+	n := copy(pkt[4:], payload)
+	for i := 4 + n; i < tsPacketSize; i++ {
+		pkt[i] = 0xff
+	}
+	return pkt
+}
+
+// Helper. PSI section with the pointer field (CRC not checked by the inspector).
+func tsSection(tableId byte, body []byte) []byte {
+	length := len(body) + 4
+	section := []byte{0, tableId, 0xb0 | byte(length>>8), byte(length)}
+	section = append(section, body...)
+	return append(section, 0, 0, 0, 0)
+}
+
+func tsPAT() []byte {
+	return tsPacket(0, true, 0, tsSection(0x00, []byte{0, 1, 0xc1, 0, 0, 0, 1, 0xe0 | testPMTPID>>8, testPMTPID & 0xff}))
+}
+
+func tsPMT() []byte {
+	body := []byte{0, 1, 0xc1, 0, 0, 0xe0 | testVideoPID>>8, testVideoPID & 0xff, 0xf0, 0}
+	body = append(body, 0x1b, 0xe0|testVideoPID>>8, testVideoPID&0xff, 0xf0, 0) // H.264
+	return tsPacket(testPMTPID, true, 0, tsSection(0x02, body))
+}
+
+// Helper. Video PES packet starting with the header with PTS (90 kHz ticks).
+func tsPES(cc int, pts uint64) []byte {
+	pes := []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5,
+		byte(0x21 | pts>>29&0x0e), byte(pts >> 22), byte(pts>>14&0xfe | 1), byte(pts >> 7), byte(pts<<1&0xfe | 1)}
+	return tsPacket(testVideoPID, true, cc, pes)
+}
+
+// Helper. Segment of PAT, PMT and video PES packets with the continuity counters and timestamps.
+func tsSegment(ccs []int, pts []uint64) []byte {
+	data := append(tsPAT(), tsPMT()...)
+	for i := range ccs {
+		data = append(data, tsPES(ccs[i], pts[i])...)
+	}
+	return data
+}
+
+func TestInspectTS(t *testing.T) {
+	const frame = 3600 // 40ms
+	wrap := uint64(tsWrap)
+
+	tests := []struct {
+		name        string
+		data        []byte
+		declared    float64
+		broken      bool
+		sync        int
+		cc          int
+		pts         int
+		duration    float64
+		badDuration bool
+	}{
+		{name: "empty", data: nil, broken: true},
+		{name: "clean", data: tsSegment([]int{0, 1, 2}, []uint64{0, frame, 2 * frame}), declared: 0.12, duration: 0.12},
+		{name: "truncated packet", data: tsSegment([]int{0, 1, 2}, []uint64{0, frame, 2 * frame})[:4*tsPacketSize+100], broken: true, duration: 0.08},
+		{name: "truncated before PMT", data: tsPAT()[:100], broken: true},
+		{name: "lost sync", data: append(tsSegment([]int{0}, []uint64{0}), make([]byte, tsPacketSize)...), broken: true, sync: 1},
+		{name: "cc wrap", data: tsSegment([]int{14, 15, 0, 1}, []uint64{0, frame, 2 * frame, 3 * frame}), duration: 0.16},
+		{name: "cc repeated", data: tsSegment([]int{3, 3, 4}, []uint64{0, frame, 2 * frame}), duration: 0.12},
+		{name: "cc lost", data: tsSegment([]int{15, 1, 2}, []uint64{0, frame, 2 * frame}), cc: 1, duration: 0.12},
+		{name: "pts wrap", data: tsSegment([]int{0, 1, 2}, []uint64{wrap - frame, 0, frame}), duration: 0.12},
+		{name: "pts jump", data: tsSegment([]int{0, 1, 2}, []uint64{0, 10 * 90000, 10*90000 + frame}), pts: 1},
+		{name: "duration mismatch", data: tsSegment([]int{0, 1, 2}, []uint64{0, frame, 2 * frame}), declared: 6, duration: 0.12, badDuration: true},
+	}
+	for _, test := range tests {
+		meta := InspectTS(test.data, test.declared)
+		if meta.Broken != test.broken || meta.SyncErrors != test.sync || meta.CCErrors != test.cc || meta.PTSJumps != test.pts || meta.BadDuration != test.badDuration {
+			t.Errorf("%s: got broken %v, sync %d, cc %d, pts %d, bad duration %v (%v)", test.name, meta.Broken, meta.SyncErrors, meta.CCErrors, meta.PTSJumps, meta.BadDuration, meta.Problems)
+		}
+		if test.duration > 0 && math.Abs(meta.Duration-test.duration) > 0.001 {
+			t.Errorf("%s: duration %.3f, expected %.3f", test.name, meta.Duration, test.duration)
+		}
+	}
+}
+
+func TestTimestampJump(t *testing.T) {
+	tests := []struct {
+		prev, next float64
+		jump       bool
+	}{
+		{0, 0.04, false},
+		{10, 9.5, false},
+		{0, 2, true},
+		{tsWrap/tsClock - 0.04, 0, false}, // wrap at 2^33
+		{tsWrap/tsClock - 0.04, 5, true},
+	}
+	for _, test := range tests {
+		if jump := timestampJump(test.prev, test.next); jump != test.jump {
+			t.Errorf("timestampJump(%f, %f) = %v", test.prev, test.next, jump)
+		}
+	}
+}
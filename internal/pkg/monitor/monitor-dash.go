@@ -0,0 +1,475 @@
+// MPEG-DASH checks for MPD manifests and segments.
+package monitor
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hotid/streamsurfer/internal/pkg/media"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Media Presentation Description. Only elements required for segment addressing parsed.
+type mpdManifest struct {
+	Type                      string      `xml:"type,attr"`
+	AvailabilityStartTime     string      `xml:"availabilityStartTime,attr"`
+	MinimumUpdatePeriod       string      `xml:"minimumUpdatePeriod,attr"`
+	MediaPresentationDuration string      `xml:"mediaPresentationDuration,attr"`
+	BaseURLs                  []string    `xml:"BaseURL"`
+	Periods                   []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	Id              string              `xml:"id,attr"`
+	Start           string              `xml:"start,attr"`
+	Duration        string              `xml:"duration,attr"`
+	BaseURLs        []string            `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentBase     *mpdSegmentBase     `xml:"SegmentBase"`
+	AdaptationSets  []mpdAdaptationSet  `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	Id              string              `xml:"id,attr"`
+	MimeType        string              `xml:"mimeType,attr"`
+	Codecs          string              `xml:"codecs,attr"`
+	BaseURLs        []string            `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentBase     *mpdSegmentBase     `xml:"SegmentBase"`
+	Representations []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	Id              string              `xml:"id,attr"`
+	Bandwidth       uint64              `xml:"bandwidth,attr"`
+	MimeType        string              `xml:"mimeType,attr"`
+	Codecs          string              `xml:"codecs,attr"`
+	BaseURLs        []string            `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentBase     *mpdSegmentBase     `xml:"SegmentBase"`
+}
+
+type mpdSegmentTemplate struct {
+	Media                  string       `xml:"media,attr"`
+	Initialization         string       `xml:"initialization,attr"`
+	StartNumber            *uint64      `xml:"startNumber,attr"`
+	Timescale              *uint64      `xml:"timescale,attr"`
+	Duration               *uint64      `xml:"duration,attr"`
+	PresentationTimeOffset *uint64      `xml:"presentationTimeOffset,attr"`
+	Timeline               *mpdTimeline `xml:"SegmentTimeline"`
+}
+
+type mpdTimeline struct {
+	S []mpdS `xml:"S"`
+}
+
+type mpdS struct {
+	T *uint64 `xml:"t,attr"`
+	D uint64  `xml:"d,attr"`
+	R int64   `xml:"r,attr"`
+}
+
+type mpdSegmentBase struct {
+	IndexRange     string  `xml:"indexRange,attr"`
+	Initialization *mpdURL `xml:"Initialization"`
+}
+
+type mpdURL struct {
+	SourceURL string `xml:"sourceURL,attr"`
+	Range     string `xml:"range,attr"`
+}
+
+// Identifiers of SegmentTemplate with optional width format ($Number%05d$).
+var templateIdentifier = regexp.MustCompile(`\$(RepresentationID|Number|Bandwidth|Time)(%0[0-9]+d)?\$`)
+
+// ISO 8601 duration as used in MPD (PnYnMnDTnHnMnS).
+var isoDuration = regexp.MustCompile(`^P(?:([0-9]+)Y)?(?:([0-9]+)M)?(?:([0-9]+)D)?(?:T(?:([0-9]+)H)?(?:([0-9]+)M)?(?:([0-9.]+)S)?)?$`)
+
+// Helper. Parse MPD from the body of the result, check dynamic MPD attributes and probe
+// initialization and the newest segment of each representation of the last period.
+// Index (sidx) of SegmentBase representations, initialization and media segments appended
+// as sub-results of the MPD.
+func probeMPD(cfg *Config, chunktasks chan *ChunkTask, task *Task, result *Result) {
+	var mpd mpdManifest
+	var chunks []*ChunkTask
+	var ast time.Time
+
+	if err := xml.Unmarshal(result.Body.Bytes(), &mpd); err != nil || len(mpd.Periods) == 0 {
+		setErr(result, BADMPD)
+		return
+	}
+	now := time.Now()
+	result.DASH = &MetaDASH{Dynamic: mpd.Type == "dynamic", Periods: len(mpd.Periods)}
+	if result.DASH.Dynamic {
+		var err error
+		if ast, err = time.Parse(time.RFC3339, strings.TrimSpace(mpd.AvailabilityStartTime)); err != nil || ast.After(now) {
+			setErr(result, BADDYNAMIC)
+		}
+		result.DASH.AvailabilityStart = ast
+		if mpd.MinimumUpdatePeriod != "" { // MPD without the attribute is not updated
+			if result.DASH.MinUpdatePeriod, err = parseISODuration(mpd.MinimumUpdatePeriod); err != nil {
+				setErr(result, BADMPD)
+			}
+		}
+	}
+	base, err := baseURL(task.URI, mpd.BaseURLs)
+	if err != nil {
+		setErr(result, BADMPD)
+		return
+	}
+	// The last period is the live one for dynamic MPD and has the newest segments for static.
+	period := mpd.Periods[len(mpd.Periods)-1]
+	periodStart, _ := parseISODuration(period.Start)
+	periodDuration, err := parseISODuration(period.Duration)
+	if err != nil && len(mpd.Periods) == 1 {
+		periodDuration, _ = parseISODuration(mpd.MediaPresentationDuration)
+		periodDuration -= periodStart
+	}
+	periodBase, err := baseURL(base.String(), period.BaseURLs)
+	if err != nil {
+		setErr(result, BADMPD)
+		return
+	}
+	inits := make(map[string]*MetaInit) // parsed initialization segments by URI and range
+	for i, as := range period.AdaptationSets {
+		asBase, err := baseURL(periodBase.String(), as.BaseURLs)
+		if err != nil {
+			setErr(result, BADMPD)
+			continue
+		}
+		for j, rep := range as.Representations {
+			repBase, err := baseURL(asBase.String(), rep.BaseURLs)
+			if err != nil {
+				setErr(result, BADMPD)
+				continue
+			}
+			meta := MetaRepresentation{Key: fmt.Sprintf("%s/%s/%s", idOr(period.Id, len(mpd.Periods)-1), idOr(as.Id, i), idOr(rep.Id, j)), Bandwidth: rep.Bandwidth, Codecs: rep.Codecs}
+			if meta.Codecs == "" {
+				meta.Codecs = as.Codecs
+			}
+			mime := rep.MimeType
+			if mime == "" {
+				mime = as.MimeType
+			}
+			var inituri, initrange, segrange string
+			tmpl := rep.SegmentTemplate.inherit(as.SegmentTemplate.inherit(period.SegmentTemplate))
+			segbase := rep.SegmentBase.inherit(as.SegmentBase.inherit(period.SegmentBase))
+			switch {
+			case tmpl != nil && tmpl.Media != "":
+				var ok bool
+				if meta.LastNumber, meta.LastTime, meta.Duration, ok = lastTemplateSegment(tmpl, result.DASH.Dynamic, ast.Add(periodStart), periodDuration, now); !ok {
+					setErr(result, BADMPD)
+					continue
+				}
+				if meta.LastURI, err = absURI(repBase, expandTemplate(tmpl.Media, rep.Id, rep.Bandwidth, meta.LastNumber, meta.LastTime)); err != nil {
+					setErr(result, BADMPD)
+					continue
+				}
+				if tmpl.Initialization != "" {
+					inituri, err = absURI(repBase, expandTemplate(tmpl.Initialization, rep.Id, rep.Bandwidth, 0, 0))
+				}
+			case segbase != nil:
+				meta.LastURI = repBase.String()
+				if segbase.Initialization != nil {
+					inituri, initrange = meta.LastURI, segbase.Initialization.Range
+					if segbase.Initialization.SourceURL != "" {
+						inituri, err = absURI(repBase, segbase.Initialization.SourceURL)
+					}
+				}
+				if segbase.IndexRange != "" {
+					var index *Result
+					index, segrange = probeIndex(cfg, task, meta.LastURI, segbase.IndexRange, &meta)
+					result.SubResults = append(result.SubResults, index)
+					setErr(result, index.ErrType)
+				}
+			default: // single segment representation addressed by BaseURL
+				meta.LastURI = repBase.String()
+			}
+			result.DASH.Representations = append(result.DASH.Representations, meta)
+			if err != nil {
+				setErr(result, BADMPD)
+				continue
+			}
+			if chunktasks == nil || !strings.Contains(mime, "mp4") && mime != "" {
+				continue
+			}
+			var init *MetaInit
+			if inituri != "" {
+				var ok bool
+				if init, ok = inits[inituri+initrange]; !ok {
+					var initresult *Result
+					offset, limit, _ := parseByteRange(initrange)
+					init, initresult = probeInit(cfg, task, inituri, offset, limit)
+					inits[inituri+initrange] = init
+					result.SubResults = append(result.SubResults, initresult)
+					setErr(result, initresult.ErrType)
+				}
+			}
+			if (tmpl == nil || tmpl.Media == "") && segrange == "" { // segments of the representation unknown
+				continue
+			}
+			chunk := &ChunkTask{Task: *subTask(task, meta.LastURI, "segment"), SeqId: meta.LastNumber, Duration: meta.Duration, Init: init}
+			chunk.Range = segrange
+			chunks = append(chunks, chunk)
+		}
+	}
+	if len(result.DASH.Representations) == 0 {
+		setErr(result, BADMPD)
+	}
+	dispatchChunks(chunktasks, chunks, result)
+}
+
+// Helper. Get segment index of SegmentBase representation and return byte range of the last subsegment.
+func probeIndex(cfg *Config, task *Task, uri, indexRange string, meta *MetaRepresentation) (*Result, string) {
+	var segrange string
+
+	indextask := subTask(task, uri, "index")
+	offset, limit, err := parseByteRange(indexRange)
+	if err != nil {
+		indextask.Range = ""
+		return &Result{Task: indextask, Route: indextask.Route, ErrType: BADMPD, Started: time.Now()}, ""
+	}
+	indextask.Range = fmt.Sprintf("bytes=%d-%d", offset, offset+limit-1)
+	result := ExecHTTP(indextask, cfg)
+	if result.ErrType < ERROR_LEVEL && result.HTTPCode < 400 {
+		data := result.Body.Bytes()
+		if result.HTTPCode != 206 && offset+limit <= int64(len(data)) { // range not supported by the server
+			data = data[offset : offset+limit]
+		}
+		refs, err := media.ParseSidx(data, offset)
+		if err != nil || len(refs) == 0 {
+			setErr(result, BADBOX)
+		} else {
+			last := refs[len(refs)-1]
+			segrange = fmt.Sprintf("bytes=%d-%d", last.Offset, last.Offset+last.Size-1)
+			meta.LastNumber = uint64(len(refs))
+			meta.Duration = last.Duration
+		}
+	}
+	result.Body.Reset() // binary data not keeped in the history
+	return result, segrange
+}
+
+// Helper. Inherit attributes of SegmentTemplate from the upper level of MPD.
+func (t *mpdSegmentTemplate) inherit(parent *mpdSegmentTemplate) *mpdSegmentTemplate {
+	if t == nil {
+		return parent
+	}
+	if parent == nil {
+		return t
+	}
+	merged := *t
+	if merged.Media == "" {
+		merged.Media = parent.Media
+	}
+	if merged.Initialization == "" {
+		merged.Initialization = parent.Initialization
+	}
+	if merged.StartNumber == nil {
+		merged.StartNumber = parent.StartNumber
+	}
+	if merged.Timescale == nil {
+		merged.Timescale = parent.Timescale
+	}
+	if merged.Duration == nil {
+		merged.Duration = parent.Duration
+	}
+	if merged.PresentationTimeOffset == nil {
+		merged.PresentationTimeOffset = parent.PresentationTimeOffset
+	}
+	if merged.Timeline == nil {
+		merged.Timeline = parent.Timeline
+	}
+	return &merged
+}
+
+// Helper. Inherit attributes of SegmentBase from the upper level of MPD.
+func (b *mpdSegmentBase) inherit(parent *mpdSegmentBase) *mpdSegmentBase {
+	if b == nil {
+		return parent
+	}
+	if parent == nil {
+		return b
+	}
+	merged := *b
+	if merged.IndexRange == "" {
+		merged.IndexRange = parent.IndexRange
+	}
+	if merged.Initialization == nil {
+		merged.Initialization = parent.Initialization
+	}
+	return &merged
+}
+
+// Helper. Find the newest segment of SegmentTemplate. For SegmentTimeline it is the last
+// segment of the timeline. Else it calculated by the segment duration from the start of the period
+// for dynamic MPD (`periodStart` is the wall clock time) or by the period duration for static MPD.
+func lastTemplateSegment(tmpl *mpdSegmentTemplate, dynamic bool, periodStart time.Time, periodDuration time.Duration, now time.Time) (number, t uint64, duration float64, ok bool) {
+	var timescale, startNumber, pto uint64 = 1, 1, 0
+
+	if tmpl.Timescale != nil && *tmpl.Timescale > 0 {
+		timescale = *tmpl.Timescale
+	}
+	if tmpl.StartNumber != nil {
+		startNumber = *tmpl.StartNumber
+	}
+	if tmpl.PresentationTimeOffset != nil {
+		pto = *tmpl.PresentationTimeOffset
+	}
+	if tmpl.Timeline != nil && len(tmpl.Timeline.S) > 0 {
+		var cur uint64
+		num := startNumber
+		for i, s := range tmpl.Timeline.S {
+			if s.D == 0 {
+				return 0, 0, 0, false
+			}
+			if s.T != nil {
+				cur = *s.T
+			}
+			repeat := s.R
+			if repeat < 0 { // repeated up to the next S, the end of the period or the live edge
+				var end uint64
+				switch {
+				case i+1 < len(tmpl.Timeline.S) && tmpl.Timeline.S[i+1].T != nil:
+					end = *tmpl.Timeline.S[i+1].T
+				case dynamic && now.After(periodStart):
+					end = pto + uint64(now.Sub(periodStart).Seconds()*float64(timescale))
+				case periodDuration > 0:
+					end = pto + uint64(periodDuration.Seconds()*float64(timescale))
+				}
+				repeat = 0
+				if end > cur+s.D {
+					repeat = int64((end-cur)/s.D) - 1
+				}
+			}
+			number, t, duration = num+uint64(repeat), cur+uint64(repeat)*s.D, float64(s.D)/float64(timescale)
+			cur += uint64(repeat+1) * s.D
+			num += uint64(repeat + 1)
+		}
+		return number, t, duration, true
+	}
+	if tmpl.Duration == nil || *tmpl.Duration == 0 {
+		return 0, 0, 0, false
+	}
+	var count uint64 = 1
+	switch {
+	case dynamic && now.After(periodStart): // completed segments only
+		count = uint64(now.Sub(periodStart).Seconds() * float64(timescale) / float64(*tmpl.Duration))
+	case !dynamic && periodDuration > 0:
+		count = uint64(math.Ceil(periodDuration.Seconds() * float64(timescale) / float64(*tmpl.Duration)))
+	}
+	if count == 0 {
+		count = 1
+	}
+	return startNumber + count - 1, pto + (count-1)*(*tmpl.Duration), float64(*tmpl.Duration) / float64(timescale), true
+}
+
+// Helper. Substitute identifiers of SegmentTemplate.
+func expandTemplate(tmpl, repId string, bandwidth, number, t uint64) string {
+	parts := strings.Split(tmpl, "$$") // escaped dollar sign
+	for i, part := range parts {
+		parts[i] = templateIdentifier.ReplaceAllStringFunc(part, func(id string) string {
+			m := templateIdentifier.FindStringSubmatch(id)
+			format := "%d"
+			if m[2] != "" {
+				format = m[2]
+			}
+			switch m[1] {
+			case "RepresentationID":
+				return repId
+			case "Number":
+				return fmt.Sprintf(format, number)
+			case "Bandwidth":
+				return fmt.Sprintf(format, bandwidth)
+			default:
+				return fmt.Sprintf(format, t)
+			}
+		})
+	}
+	return strings.Join(parts, "$")
+}
+
+// Helper. Resolve the first BaseURL of the MPD element against the parent URL.
+func baseURL(parent string, baseURLs []string) (*url.URL, error) {
+	base, err := url.Parse(parent)
+	if err != nil || len(baseURLs) == 0 || strings.TrimSpace(baseURLs[0]) == "" {
+		return base, err
+	}
+	return base.Parse(strings.TrimSpace(baseURLs[0]))
+}
+
+// Helper. Use index of the element when id attribute not set.
+func idOr(id string, idx int) string {
+	if id != "" {
+		return id
+	}
+	return strconv.Itoa(idx)
+}
+
+// Helper. Parse ISO 8601 duration. Years and months approximated.
+func parseISODuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	m := isoDuration.FindStringSubmatch(s)
+	if m == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, fmt.Errorf("bad duration %q", s)
+	}
+	var total float64
+	for i, unit := range []float64{365 * 86400, 30 * 86400, 86400, 3600, 60, 1} {
+		if m[i+1] != "" {
+			val, err := strconv.ParseFloat(m[i+1], 64)
+			if err != nil {
+				return 0, err
+			}
+			total += val * unit
+		}
+	}
+	return time.Duration(total * float64(time.Second)), nil
+}
+
+// Helper. Parse byte range "first-last" of MPD to offset and length.
+func parseByteRange(s string) (offset, limit int64, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	bounds := strings.SplitN(s, "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("bad range %q", s)
+	}
+	if offset, err = strconv.ParseInt(bounds[0], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	last, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil || last < offset {
+		return 0, 0, fmt.Errorf("bad range %q", s)
+	}
+	return offset, last - offset + 1, nil
+}
+
+// Helper. Detect dynamic MPD which timeline not advanced during `stale-factor` segment durations
+// (or minimum update periods when it longer). The state of representations kept by StreamBox between the tasks.
+func checkTimeline(cfg *Config, stream Stream, playlists map[string]PlaylistState, result *Result) {
+	if result.DASH == nil || !result.DASH.Dynamic || result.Task == nil {
+		return
+	}
+	factor := cfg.Params(stream.Group).StaleFactor
+	if factor <= 0 {
+		factor = defaultStaleFactor
+	}
+	for _, rep := range result.DASH.Representations {
+		key := result.Task.URI + "#" + rep.Key
+		prev, ok := playlists[key]
+		if !ok || prev.LastSeqId != rep.LastNumber || prev.LastURI != rep.LastURI {
+			playlists[key] = PlaylistState{SeqNo: rep.LastNumber, LastSeqId: rep.LastNumber, LastURI: rep.LastURI, Changed: result.Started}
+			continue
+		}
+		interval := math.Max(rep.Duration, result.DASH.MinUpdatePeriod.Seconds())
+		if interval > 0 && result.Started.Sub(prev.Changed) > time.Duration(factor*interval*float64(time.Second)) {
+			setErr(result, STALEMPD)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseISODuration(t *testing.T) {
+	tests := []struct {
+		s        string
+		duration time.Duration
+		err      bool
+	}{
+		{s: "PT2S", duration: 2 * time.Second},
+		{s: "PT1.5S", duration: 1500 * time.Millisecond},
+		{s: "PT1H2M3S", duration: time.Hour + 2*time.Minute + 3*time.Second},
+		{s: "P1DT1H", duration: 25 * time.Hour},
+		{s: " PT4S ", duration: 4 * time.Second},
+		{s: "PT0S"},
+		{s: "", err: true},
+		{s: "P", err: true},
+		{s: "PT", err: true},
+		{s: "2S", err: true},
+		{s: "PT1.5.5S", err: true},
+	}
+	for _, test := range tests {
+		duration, err := parseISODuration(test.s)
+		if (err != nil) != test.err || duration != test.duration {
+			t.Errorf("parseISODuration(%q) = %s, %v", test.s, duration, err)
+		}
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl, uri string
+	}{
+		{"$RepresentationID$/seg-$Number$.m4s", "v1/seg-42.m4s"},
+		{"$RepresentationID$/seg-$Number%05d$.m4s", "v1/seg-00042.m4s"},
+		{"chunk-$Bandwidth$-$Time$.m4s", "chunk-800000-90000.m4s"},
+		{"t$Time%012d$.m4s", "t000000090000.m4s"},
+		{"price$$-$Number$", "price$-42"},
+		{"init.mp4", "init.mp4"},
+		{"$Unknown$-$Number$", "$Unknown$-42"},
+	}
+	for _, test := range tests {
+		if uri := expandTemplate(test.tmpl, "v1", 800000, 42, 90000); uri != test.uri {
+			t.Errorf("expandTemplate(%q) = %q, expected %q", test.tmpl, uri, test.uri)
+		}
+	}
+}
+
+func TestLastTemplateSegment(t *testing.T) {
+	u := func(v uint64) *uint64 { return &v }
+	now := time.Now()
+	timeline := func(s ...mpdS) *mpdTimeline { return &mpdTimeline{S: s} }
+
+	tests := []struct {
+		name           string
+		tmpl           mpdSegmentTemplate
+		dynamic        bool
+		periodStart    time.Time
+		periodDuration time.Duration
+		number, t      uint64
+		duration       float64
+		ok             bool
+	}{
+		{
+			name:   "timeline with repeats",
+			tmpl:   mpdSegmentTemplate{Timescale: u(1000), StartNumber: u(10), Timeline: timeline(mpdS{T: u(0), D: 2000, R: 2}, mpdS{D: 1000})},
+			number: 13, t: 6000, duration: 1, ok: true,
+		},
+		{
+			name:   "S@r=-1 up to the next S",
+			tmpl:   mpdSegmentTemplate{Timescale: u(1000), Timeline: timeline(mpdS{T: u(0), D: 1000, R: -1}, mpdS{T: u(10000), D: 1000})},
+			number: 11, t: 10000, duration: 1, ok: true,
+		},
+		{
+			name:    "S@r=-1 up to the live edge",
+			tmpl:    mpdSegmentTemplate{Timescale: u(1000), Timeline: timeline(mpdS{T: u(0), D: 2000, R: -1})},
+			dynamic: true, periodStart: now.Add(-61 * time.Second),
+			number: 30, t: 58000, duration: 2, ok: true,
+		},
+		{
+			name:    "S@r=-1 up to the live edge with presentation time offset",
+			tmpl:    mpdSegmentTemplate{Timescale: u(1000), PresentationTimeOffset: u(100000), Timeline: timeline(mpdS{T: u(100000), D: 2000, R: -1})},
+			dynamic: true, periodStart: now.Add(-61 * time.Second),
+			number: 30, t: 158000, duration: 2, ok: true,
+		},
+		{
+			name:           "S@r=-1 up to the end of the period",
+			tmpl:           mpdSegmentTemplate{Timescale: u(1000), Timeline: timeline(mpdS{T: u(0), D: 2000, R: -1})},
+			periodDuration: 20 * time.Second,
+			number:         10, t: 18000, duration: 2, ok: true,
+		},
+		{
+			name:   "S@r=-1 without the end",
+			tmpl:   mpdSegmentTemplate{Timescale: u(1000), Timeline: timeline(mpdS{T: u(4000), D: 2000, R: -1})},
+			number: 1, t: 4000, duration: 2, ok: true,
+		},
+		{
+			name: "zero duration in timeline",
+			tmpl: mpdSegmentTemplate{Timeline: timeline(mpdS{D: 0})},
+		},
+		{
+			name:    "live by duration",
+			tmpl:    mpdSegmentTemplate{Timescale: u(1000), StartNumber: u(5), Duration: u(2000)},
+			dynamic: true, periodStart: now.Add(-61 * time.Second),
+			number: 34, t: 58000, duration: 2, ok: true,
+		},
+		{
+			name:    "live not started yet",
+			tmpl:    mpdSegmentTemplate{Timescale: u(1000), Duration: u(2000)},
+			dynamic: true, periodStart: now.Add(time.Minute),
+			number: 1, t: 0, duration: 2, ok: true,
+		},
+		{
+			name:           "VOD by duration",
+			tmpl:           mpdSegmentTemplate{Duration: u(4)},
+			periodDuration: 42 * time.Second,
+			number:         11, t: 40, duration: 4, ok: true,
+		},
+		{
+			name: "no duration",
+			tmpl: mpdSegmentTemplate{Timescale: u(1000)},
+		},
+	}
+	for _, test := range tests {
+		number, ts, duration, ok := lastTemplateSegment(&test.tmpl, test.dynamic, test.periodStart, test.periodDuration, now)
+		if number != test.number || ts != test.t || duration != test.duration || ok != test.ok {
+			t.Errorf("%s: got number %d, time %d, duration %f, ok %v", test.name, number, ts, duration, ok)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// HTTP Dynamic Streaming checks for F4M manifests, bootstrap info and fragments.
+package monitor
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"github.com/hotid/streamsurfer/internal/pkg/media"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"net/url"
+	"strings"
+)
+
+// Set-level manifests refer stream-level ones. Deeper links not followed.
+const maxManifestDepth = 1
+
+// F4M manifest (versions 1.0 and 2.0).
+type f4mManifest struct {
+	StreamType string         `xml:"streamType"`
+	BaseURL    string         `xml:"baseURL"`
+	Bootstraps []f4mBootstrap `xml:"bootstrapInfo"`
+	Medias     []f4mMedia     `xml:"media"`
+}
+
+type f4mBootstrap struct {
+	Id   string `xml:"id,attr"`
+	URL  string `xml:"url,attr"`
+	Data string `xml:",chardata"` // base64 encoded abst box when url not set
+}
+
+type f4mMedia struct {
+	URL             string `xml:"url,attr"`
+	Href            string `xml:"href,attr"` // stream-level manifest (F4M 2.0)
+	Bitrate         string `xml:"bitrate,attr"`
+	BootstrapInfoId string `xml:"bootstrapInfoId,attr"`
+}
+
+// Helper. Parse F4M manifest from the body of the result, decode bootstrap info of each media and
+// pass the newest fragments to media probers. Bootstrap info retrieved by URL, stream-level manifests
+// and fragments appended as sub-results of the manifest.
+func probeManifest(cfg *Config, chunktasks chan *ChunkTask, task *Task, result *Result, depth int) {
+	var manifest f4mManifest
+	var chunks []*ChunkTask
+
+	if err := xml.Unmarshal(result.Body.Bytes(), &manifest); err != nil || len(manifest.Medias) == 0 {
+		setErr(result, BADMANIFEST)
+		return
+	}
+	base, err := url.Parse(task.URI)
+	if err == nil && strings.TrimSpace(manifest.BaseURL) != "" {
+		base, err = base.Parse(strings.TrimSpace(manifest.BaseURL))
+	}
+	if err != nil {
+		setErr(result, BADMANIFEST)
+		return
+	}
+	result.HDS = &MetaHDS{Live: strings.TrimSpace(manifest.StreamType) == "live"}
+	bootstraps := make(map[string]*media.Bootstrap) // decoded bootstrap info by id
+	for _, m := range manifest.Medias {
+		if m.Href != "" { // set-level manifest
+			href, err := absURI(base, m.Href)
+			if err != nil {
+				setErr(result, BADMANIFEST)
+				continue
+			}
+			result.HDS.DeepLinks = append(result.HDS.DeepLinks, href)
+			if depth == 0 {
+				continue
+			}
+			subtask := subTask(task, href, "manifest")
+			subresult := ExecHTTP(subtask, cfg)
+			if subresult.ErrType < ERROR_LEVEL && subresult.HTTPCode < 400 && subresult.RealContentLength > 0 {
+				probeManifest(cfg, chunktasks, subtask, subresult, depth-1)
+			}
+			result.SubResults = append(result.SubResults, subresult)
+			setErr(result, subresult.ErrType)
+			continue
+		}
+		mediauri, err := absURI(base, m.URL)
+		if err != nil || m.URL == "" {
+			setErr(result, BADMANIFEST)
+			continue
+		}
+		result.HDS.DeepLinks = append(result.HDS.DeepLinks, mediauri)
+		info := manifest.bootstrap(m.BootstrapInfoId)
+		if info == nil {
+			setErr(result, BADBOOTSTRAP)
+			continue
+		}
+		boot, ok := bootstraps[info.Id]
+		if !ok {
+			boot = probeBootstrap(cfg, task, result, base, info)
+			bootstraps[info.Id] = boot
+		}
+		if boot == nil { // problem already reported
+			continue
+		}
+		segment, fragment, duration := boot.LastFragment()
+		if fragment == 0 {
+			setErr(result, BADBOOTSTRAP)
+			continue
+		}
+		result.HDS.MediaTime = boot.MediaTime()
+		result.HDS.LastSegment = segment
+		result.HDS.LastFragment = fragment
+		if chunktasks != nil {
+			chunks = append(chunks, &ChunkTask{Task: *subTask(task, fragmentURI(mediauri, segment, fragment), "fragment"), SeqId: uint64(fragment), Duration: duration})
+		}
+	}
+	dispatchChunks(chunktasks, chunks, result)
+}
+
+// Helper. Get bootstrap info by id. The single bootstrap info used for media without id.
+func (m *f4mManifest) bootstrap(id string) *f4mBootstrap {
+	for i, info := range m.Bootstraps {
+		if info.Id == id || id == "" && len(m.Bootstraps) == 1 {
+			return &m.Bootstraps[i]
+		}
+	}
+	return nil
+}
+
+// Helper. Decode inline bootstrap info or retrieve it by URL. Retrieved bootstrap info
+// appended as sub-result of the manifest. Returns nil on errors.
+func probeBootstrap(cfg *Config, task *Task, result *Result, base *url.URL, info *f4mBootstrap) *media.Bootstrap {
+	var data []byte
+	var err error
+
+	target := result
+	if info.URL != "" {
+		uri, err := absURI(base, info.URL)
+		if err != nil {
+			setErr(result, BADBOOTSTRAP)
+			return nil
+		}
+		boottask := subTask(task, uri, "bootstrap")
+		target = ExecHTTP(boottask, cfg)
+		result.SubResults = append(result.SubResults, target)
+		data = make([]byte, target.Body.Len())
+		copy(data, target.Body.Bytes())
+		target.Body.Reset() // binary data not keeped in the history
+		if target.ErrType >= ERROR_LEVEL || target.HTTPCode >= 400 {
+			setErr(result, target.ErrType)
+			return nil
+		}
+	} else if data, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(info.Data), "")); err != nil {
+		setErr(result, BADBOOTSTRAP)
+		return nil
+	}
+	boot, err := media.ParseBootstrap(data)
+	if err != nil {
+		fmt.Printf("Bootstrap info of %s: %s\n", task.URI, err)
+		setErr(target, BADBOOTSTRAP)
+		setErr(result, BADBOOTSTRAP)
+		return nil
+	}
+	if target != result {
+		segment, fragment, _ := boot.LastFragment()
+		target.HDS = &MetaHDS{Live: boot.Live, MediaTime: boot.MediaTime(), LastSegment: segment, LastFragment: fragment}
+	}
+	return boot
+}
+
+// Helper. Make URL of the fragment from the media URL.
+func fragmentURI(mediauri string, segment, fragment uint32) string {
+	var query string
+
+	if i := strings.IndexByte(mediauri, '?'); i >= 0 {
+		mediauri, query = mediauri[:i], mediauri[i:]
+	}
+	return fmt.Sprintf("%sSeg%d-Frag%d%s", mediauri, segment, fragment, query)
+}
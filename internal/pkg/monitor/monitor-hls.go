@@ -0,0 +1,482 @@
+// HTTP Live Streaming checks for media playlists and segments.
+package monitor
+
+import (
+	"fmt"
+	"github.com/grafov/m3u8"
+	"github.com/hotid/streamsurfer/internal/pkg/media"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Live playlist considered stale after this number of target durations without changes
+// if `stale-factor` not set for the group.
+const defaultStaleFactor = 3.
+
+// Allowed gap between base media decode time of fMP4 segment and the end of the previous one (sec).
+const maxDecodeTimeGap = 0.1
+
+// Helper. Probe media playlists of the master playlist: variants, I-frame playlists and
+// EXT-X-MEDIA renditions. Sub-results labeled with the kind of the playlist.
+// Rendition groups referenced by variants checked for existence.
+func probeMaster(cfg *Config, chunktasks chan *ChunkTask, task *Task, result *Result, m *m3u8.MasterPlaylist) {
+	type sublist struct {
+		uri, kind, rendition string
+	}
+	var sublists []sublist
+
+	result.HLS = &MetaHLS{ListType: m3u8.MASTER}
+	mainuri, err := url.Parse(task.URI)
+	if err != nil {
+		result.ErrType = UNKERR
+		return
+	}
+	groups := make(map[string]bool) // declared groups by TYPE/GROUP-ID
+	for _, alt := range hlsRenditions(result.Body.Bytes()) {
+		groups[alt.Type+"/"+alt.GroupId] = true
+		if alt.URI != "" { // CLOSED-CAPTIONS and muxed renditions have no own playlist
+			sublists = append(sublists, sublist{alt.URI, strings.ToLower(alt.Type), strings.TrimSpace(alt.GroupId + " " + alt.Name)})
+		}
+	}
+	for _, variant := range m.Variants {
+		kind := "media"
+		if variant.Iframe {
+			kind = "iframe"
+		}
+		sublists = append(sublists, sublist{variant.URI, kind, ""})
+		for _, ref := range []struct{ typ, group string }{{"AUDIO", variant.Audio}, {"VIDEO", variant.Video}, {"SUBTITLES", variant.Subtitles}, {"CLOSED-CAPTIONS", variant.Captions}} {
+			if ref.group != "" && ref.group != "NONE" && !groups[ref.typ+"/"+ref.group] {
+				result.HLS.MissingGroups = append(result.HLS.MissingGroups, ref.typ+"/"+ref.group)
+				setErr(result, BADGROUP)
+			}
+		}
+	}
+	subresult := make(chan *Result, len(sublists))
+	probed := make(map[string]bool)
+	taskCount := 0
+	for _, sub := range sublists {
+		suburi, err := absURI(mainuri, sub.uri)
+		if err != nil {
+			result.SubResults = append(result.SubResults, &Result{Task: subTask(task, sub.uri, sub.kind), Route: task.Route, ErrType: BADURI, Started: time.Now()})
+			setErr(result, BADURI)
+			continue
+		}
+		if probed[suburi] { // same playlist referenced by several renditions
+			continue
+		}
+		probed[suburi] = true
+		result.HLS.DeepLinks = append(result.HLS.DeepLinks, suburi)
+		subtask := subTask(task, suburi, sub.kind)
+		subtask.ReadBody = task.ReadBody
+		state, known := task.Playlists[suburi]
+		go func(subtask *Task, rendition string, state PlaylistState, known bool) {
+			listresult, p := probeMediaList(subtask, cfg)
+			if listresult.HLS != nil {
+				listresult.HLS.Rendition = rendition
+			}
+			if p != nil && subtask.Kind != "iframe" { // I-frame playlists refer ranges of usual segments
+				probeSegments(cfg, chunktasks, listresult, p, state, known)
+			}
+			subresult <- listresult
+		}(subtask, sub.rendition, state, known)
+		taskCount++
+	}
+	for taskCount > 0 {
+		select {
+		case data := <-subresult:
+			result.SubResults = append(result.SubResults, data)
+			setErr(result, data.ErrType) // media playlist problems are problems of the whole stream
+			if data.HLS != nil && data.HLS.Latency > result.HLS.Latency {
+				result.HLS.Latency = data.HLS.Latency
+			}
+		case <-time.After(60 * time.Second):
+		}
+		taskCount--
+	}
+}
+
+// Helper. Get EXT-X-MEDIA renditions of the master playlist.
+// Parsed from the raw body because m3u8 lib drops renditions declared after the last variant.
+func hlsRenditions(body []byte) []*m3u8.Alternative {
+	var renditions []*m3u8.Alternative
+
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#EXT-X-MEDIA:") {
+			continue
+		}
+		alt := new(m3u8.Alternative)
+		for key, val := range hlsAttrs(line[13:]) {
+			switch key {
+			case "TYPE":
+				alt.Type = val
+			case "GROUP-ID":
+				alt.GroupId = val
+			case "NAME":
+				alt.Name = val
+			case "LANGUAGE":
+				alt.Language = val
+			case "URI":
+				alt.URI = val
+			}
+		}
+		renditions = append(renditions, alt)
+	}
+	return renditions
+}
+
+// Helper. Parse attribute list of HLS tag. Quotes removed from quoted strings.
+func hlsAttrs(line string) map[string]string {
+	attrs := make(map[string]string)
+	for line != "" {
+		var val string
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(line[:eq])
+		line = line[eq+1:]
+		if strings.HasPrefix(line, "\"") {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				end = len(line) - 1
+			}
+			val = line[1 : end+1]
+			line = line[end+1:]
+			if line != "" {
+				line = line[1:] // closing quote
+			}
+		}
+		comma := strings.IndexByte(line, ',')
+		if comma < 0 {
+			comma = len(line)
+		}
+		if val == "" {
+			val = strings.TrimSpace(line[:comma])
+		}
+		attrs[key] = val
+		if comma < len(line) {
+			comma++
+		}
+		line = line[comma:]
+	}
+	return attrs
+}
+
+// Helper. Get media playlist and verify it. Parsed playlist returned when it is valid.
+func probeMediaList(task *Task, cfg *Config) (*Result, *m3u8.MediaPlaylist) {
+	result := ExecHTTP(task, cfg)
+	if result.ErrType >= ERROR_LEVEL || result.HTTPCode >= 400 || result.ContentLength == 0 {
+		return result, nil
+	}
+	playlist, listType, err := m3u8.Decode(result.Body, true)
+	if err != nil || listType != m3u8.MEDIA {
+		setErr(result, BADFORMAT)
+		return result, nil
+	}
+	p := playlist.(*m3u8.MediaPlaylist)
+	verifyHLS(cfg, task, result, p)
+	probeLowLatency(cfg, task, result)
+	return result, p
+}
+
+// Media segment with tags applied to it.
+type segmentRef struct {
+	*m3u8.MediaSegment
+	xmap *m3u8.Map // effective EXT-X-MAP
+	xkey *m3u8.Key // effective EXT-X-KEY
+}
+
+// Helper. Pass media segments of the playlist to media probers and wait for the results.
+// Only the last segment probed when `one-segment` option set. Else all segments of the live
+// playlist appeared since the previous task probed (the last one for the first task).
+// Initialization segments (EXT-X-MAP) of fMP4 streams and encryption keys (EXT-X-KEY)
+// probed before media segments. Segments with EXT-X-BYTERANGE requested by range. Results of all checks appended to the playlist result.
+func probeSegments(cfg *Config, chunktasks chan *ChunkTask, list *Result, p *m3u8.MediaPlaylist, state PlaylistState, known bool) {
+	var segments, selected []segmentRef
+	var curmap *m3u8.Map
+	var curkey *m3u8.Key
+
+	if chunktasks == nil || list.HLS == nil || list.ErrType >= CRITICAL_LEVEL {
+		return
+	}
+	base, err := url.Parse(list.Task.URI)
+	if err != nil {
+		return
+	}
+	for _, seg := range p.Segments {
+		if seg != nil {
+			if seg.Map != nil {
+				curmap = seg.Map
+			}
+			if seg.Key != nil {
+				curkey = seg.Key
+			}
+			segments = append(segments, segmentRef{seg, curmap, curkey})
+		}
+	}
+	if len(segments) == 0 {
+		return
+	}
+	if known && list.HLS.Live && !cfg.Params(list.Task.Group).TryOneSegment {
+		for _, seg := range segments {
+			if seg.SeqId > state.LastSeqId {
+				selected = append(selected, seg)
+			}
+		}
+	} else {
+		selected = segments[len(segments)-1:]
+	}
+	inits := make(map[string]*MetaInit) // parsed initialization segments by URI
+	keys := make(map[string][]byte)     // retrieved keys by URI
+	byURI := make(map[string]*m3u8.MediaSegment)
+	var chunks []*ChunkTask
+	for _, seg := range selected {
+		if !validSegURI(seg.URI) { // already reported for the playlist
+			continue
+		}
+		uri, err := absURI(base, seg.URI)
+		if err != nil {
+			continue
+		}
+		chunktask := &ChunkTask{Task: *subTask(list.Task, uri, "segment"), SeqId: seg.SeqId, Duration: seg.Duration}
+		if seg.Limit > 0 { // EXT-X-BYTERANGE: sub-range of the resource is the segment
+			chunktask.Range = fmt.Sprintf("bytes=%d-%d", seg.Offset, seg.Offset+seg.Limit-1)
+			chunktask.Limit = seg.Limit
+		}
+		if seg.xmap != nil {
+			inituri, err := absURI(base, seg.xmap.URI)
+			if err != nil {
+				setErr(list, BADSEGURI)
+				continue
+			}
+			var ok bool
+			if chunktask.Init, ok = inits[inituri]; !ok {
+				var initresult *Result
+				chunktask.Init, initresult = probeInit(cfg, list.Task, inituri, seg.xmap.Offset, seg.xmap.Limit)
+				inits[inituri] = chunktask.Init
+				list.SubResults = append(list.SubResults, initresult)
+				setErr(list, initresult.ErrType)
+			}
+		}
+		if seg.xkey != nil && seg.xkey.Method != "NONE" && seg.xkey.Method != "" {
+			chunktask.Method = seg.xkey.Method
+			keyuri, err := absURI(base, seg.xkey.URI)
+			if err != nil || seg.xkey.URI == "" {
+				setErr(list, BADKEY)
+			} else if strings.HasPrefix(keyuri, "http://") || strings.HasPrefix(keyuri, "https://") { // skip DRM specific schemes
+				var ok bool
+				if chunktask.Key, ok = keys[keyuri]; !ok {
+					var keyresult *Result
+					chunktask.Key, keyresult = probeKey(cfg, list.Task, keyuri)
+					keys[keyuri] = chunktask.Key
+					list.SubResults = append(list.SubResults, keyresult)
+					setErr(list, keyresult.ErrType)
+				}
+			}
+			if chunktask.IV, err = media.SegmentIV(seg.xkey.IV, seg.SeqId); err != nil {
+				setErr(list, BADKEY)
+				chunktask.Key = nil
+			}
+		}
+		byURI[uri] = seg.MediaSegment
+		chunks = append(chunks, chunktask)
+	}
+	replies := dispatchChunks(chunktasks, chunks, list)
+	checkDecodeTime(replies, byURI)
+	for _, data := range replies { // decode time mismatches found after all segments probed
+		setErr(list, data.ErrType)
+	}
+}
+
+// Helper. Get encryption key with credentials of the group and check its length.
+// Key data not keeped in the history.
+func probeKey(cfg *Config, task *Task, uri string) ([]byte, *Result) {
+	var key []byte
+
+	keytask := subTask(task, uri, "key")
+	keytask.Auth = true
+	result := ExecHTTP(keytask, cfg)
+	if result.ErrType < ERROR_LEVEL && result.HTTPCode < 400 {
+		if result.Body.Len() == 16 { // AES-128 key
+			key = make([]byte, 16)
+			copy(key, result.Body.Bytes())
+		} else {
+			setErr(result, BADKEY)
+		}
+	}
+	result.Body.Reset()
+	return key, result
+}
+
+// Helper. Get fMP4 initialization segment and parse it. Only `limit` bytes from `offset`
+// requested when limit set (EXT-X-MAP with BYTERANGE, DASH Initialization@range).
+func probeInit(cfg *Config, task *Task, uri string, offset, limit int64) (*MetaInit, *Result) {
+	var init *MetaInit
+
+	inittask := subTask(task, uri, "init")
+	if limit > 0 {
+		inittask.Range = fmt.Sprintf("bytes=%d-%d", offset, offset+limit-1)
+	}
+	result := ExecHTTP(inittask, cfg)
+	if result.ErrType < ERROR_LEVEL && result.HTTPCode < 400 && result.RealContentLength > 0 {
+		data := result.Body.Bytes()
+		if limit > 0 && result.HTTPCode != http.StatusPartialContent { // range not supported by the server
+			if offset+limit <= int64(len(data)) {
+				data = data[offset : offset+limit]
+			} else {
+				data = nil
+			}
+		}
+		init, result.Media = media.InspectInit(data)
+		if result.Media.Broken {
+			setErr(result, BADBOX)
+		}
+	}
+	result.Body.Reset() // media data not keeped in the history
+	return init, result
+}
+
+// Helper. Check that base media decode time of each fMP4 segment continues the previous segment.
+// Segments after EXT-X-DISCONTINUITY not checked.
+func checkDecodeTime(chunks []*Result, segments map[string]*m3u8.MediaSegment) {
+	var ordered []*Result
+
+	for _, data := range chunks {
+		if data.Media != nil && data.Media.Container == "fmp4" && data.Media.DecodeTime >= 0 && segments[data.Task.URI] != nil {
+			ordered = append(ordered, data)
+		}
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return segments[ordered[i].Task.URI].SeqId < segments[ordered[j].Task.URI].SeqId
+	})
+	for i := 1; i < len(ordered); i++ {
+		prev, cur := ordered[i-1], ordered[i]
+		seg := segments[cur.Task.URI]
+		if seg.Discontinuity || seg.SeqId != segments[prev.Task.URI].SeqId+1 {
+			continue
+		}
+		expected := prev.Media.DecodeTime + prev.Media.Duration
+		if math.Abs(cur.Media.DecodeTime-expected) > maxDecodeTimeGap {
+			cur.Media.Problems = append(cur.Media.Problems, fmt.Sprintf("decode time %.3fs but previous segment ends at %.3fs", cur.Media.DecodeTime, expected))
+			setErr(cur, BADDECODETIME)
+		}
+	}
+}
+
+// Helper. Verify HLS specific things.
+func verifyHLS(cfg *Config, task *Task, result *Result, p *m3u8.MediaPlaylist) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("trace dumped in HLS parser:", r)
+			result.ErrType = HLSPARSER
+		}
+	}()
+
+	live := !p.Closed && p.MediaType != m3u8.VOD
+	target, seqfound := hlsHeaders(result.Body.Bytes())
+	result.HLS = &MetaHLS{ListType: m3u8.MEDIA, Live: live, TargetDuration: target, SeqNo: p.SeqNo}
+	if p.Count() == 0 {
+		setErr(result, LISTEMPTY)
+		return
+	}
+	if live && !seqfound {
+		setErr(result, NOSEQUENCE)
+	}
+	var edge time.Time // end of the newest segment by EXT-X-PROGRAM-DATE-TIME
+	for _, seg := range p.Segments {
+		if seg == nil {
+			continue
+		}
+		if !seg.ProgramDateTime.IsZero() {
+			edge = seg.ProgramDateTime
+		}
+		if !edge.IsZero() {
+			edge = edge.Add(time.Duration(seg.Duration * float64(time.Second)))
+		}
+		// Spec requires EXTINF rounded to the nearest integer be not greater than the target duration.
+		if target > 0 && math.Floor(seg.Duration+0.5) > target {
+			setErr(result, BADDURATION)
+		}
+		if !validSegURI(seg.URI) {
+			setErr(result, BADSEGURI)
+		}
+		result.HLS.LastSeqId = seg.SeqId
+		result.HLS.LastURI = seg.URI
+	}
+	if live && !edge.IsZero() {
+		result.HLS.Latency = result.Started.Add(result.Elapsed).Sub(edge)
+		checkLatency(cfg, task.Group, result, result.HLS.Latency)
+	}
+}
+
+// Helper. Compare live latency with thresholds of the group.
+func checkLatency(cfg *Config, group string, result *Result, latency time.Duration) {
+	params := cfg.Params(group)
+	switch {
+	case params.LatencyError > 0 && latency >= params.LatencyError*time.Second:
+		setErr(result, VERYLATENCY)
+	case params.LatencyWarning > 0 && latency >= params.LatencyWarning*time.Second:
+		setErr(result, LATENCY)
+	}
+}
+
+// Helper. Detect live media playlists not advanced during `stale-factor` target durations.
+// The state of playlists kept by StreamBox between the tasks.
+func checkStale(cfg *Config, stream Stream, playlists map[string]PlaylistState, result *Result) {
+	factor := cfg.Params(stream.Group).StaleFactor
+	if factor <= 0 {
+		factor = defaultStaleFactor
+	}
+	for _, res := range append([]*Result{result}, result.SubResults...) {
+		if res.HLS == nil || !res.HLS.Live || res.Task == nil {
+			continue
+		}
+		prev, ok := playlists[res.Task.URI]
+		if !ok || prev.SeqNo != res.HLS.SeqNo || prev.LastURI != res.HLS.LastURI {
+			playlists[res.Task.URI] = PlaylistState{SeqNo: res.HLS.SeqNo, LastSeqId: res.HLS.LastSeqId, LastURI: res.HLS.LastURI, Changed: res.Started}
+			continue
+		}
+		if res.HLS.TargetDuration > 0 && res.Started.Sub(prev.Changed) > time.Duration(factor*res.HLS.TargetDuration*float64(time.Second)) {
+			setErr(res, STALEPLAYLIST)
+			setErr(result, STALEPLAYLIST)
+		}
+	}
+}
+
+// Helper. Get declared EXT-X-TARGETDURATION value and presence of EXT-X-MEDIA-SEQUENCE from the raw playlist.
+// m3u8 lib silently increases target duration for the longer segments so it can't be used for the check.
+func hlsHeaders(body []byte) (target float64, seqfound bool) {
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			target, _ = strconv.ParseFloat(line[22:], 64)
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			seqfound = true
+		}
+	}
+	return
+}
+
+// Helper. Check segment URI for the format errors.
+func validSegURI(ref string) bool {
+	if ref == "" || strings.IndexFunc(ref, unicode.IsSpace) >= 0 {
+		return false
+	}
+	uri, err := url.Parse(ref)
+	if err != nil {
+		return false
+	}
+	if uri.IsAbs() && uri.Scheme != "http" && uri.Scheme != "https" {
+		return false
+	}
+	return true
+}
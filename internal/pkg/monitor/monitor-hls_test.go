@@ -0,0 +1,100 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+)
+
+func TestHlsAttrs(t *testing.T) {
+	tests := []struct {
+		line  string
+		attrs map[string]string
+	}{
+		{``, map[string]string{}},
+		{`BANDWIDTH=1280000`, map[string]string{"BANDWIDTH": "1280000"}},
+		{`TYPE=AUDIO,GROUP-ID="aac",NAME="English, main",DEFAULT=YES`, map[string]string{"TYPE": "AUDIO", "GROUP-ID": "aac", "NAME": "English, main", "DEFAULT": "YES"}},
+		{`URI="",NAME=x`, map[string]string{"URI": "", "NAME": "x"}},
+		{`URI="a.m3u8`, map[string]string{"URI": "a.m3u8"}}, // unterminated quote
+		{` PART-TARGET=1.004 , CAN-BLOCK-RELOAD=YES`, map[string]string{"PART-TARGET": "1.004", "CAN-BLOCK-RELOAD": "YES"}},
+		{`RESOLUTION=640x360,`, map[string]string{"RESOLUTION": "640x360"}},
+		{`NONE`, map[string]string{}},
+	}
+	for _, test := range tests {
+		if attrs := hlsAttrs(test.line); !reflect.DeepEqual(attrs, test.attrs) {
+			t.Errorf("hlsAttrs(%q) = %v, expected %v", test.line, attrs, test.attrs)
+		}
+	}
+}
+
+func TestHlsRenditions(t *testing.T) {
+	body := `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="aac"
+low.m3u8
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",LANGUAGE="en",URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID="cc",NAME="CC1",INSTREAM-ID="CC1"
+`
+	renditions := hlsRenditions([]byte(body))
+	if len(renditions) != 2 {
+		t.Fatalf("got %d renditions", len(renditions))
+	}
+	if r := renditions[0]; r.Type != "AUDIO" || r.GroupId != "aac" || r.Name != "English" || r.Language != "en" || r.URI != "audio/en.m3u8" {
+		t.Errorf("audio rendition parsed as %+v", r)
+	}
+	if r := renditions[1]; r.Type != "CLOSED-CAPTIONS" || r.URI != "" {
+		t.Errorf("captions rendition parsed as %+v", r)
+	}
+}
+
+func TestChunkRange(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/norange": // range ignored by the server
+			w.Write([]byte(content))
+		case "/short": // less data than requested
+			w.Header().Set("Content-Range", "bytes 100-149/1000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(content[100:150]))
+		default:
+			http.ServeContent(w, r, "segment.ts", time.Time{}, strings.NewReader(content))
+		}
+	}))
+	defer srv.Close()
+	cfg := testConfig(ConfigGroup{})
+
+	tests := []struct {
+		name          string
+		path          string
+		offset, limit int64
+		err           ErrType
+	}{
+		{"whole resource", "/file", 0, 0, SUCCESS},
+		{"byte range", "/file", 100, 200, SUCCESS},
+		{"range ignored", "/norange", 100, 200, BADRANGE},
+		{"short range", "/short", 100, 200, BADLENGTH},
+	}
+	for _, test := range tests {
+		task := &ChunkTask{Task: *testTask(srv.URL+test.path, Route{})}
+		task.ReadBody = true
+		if test.limit > 0 {
+			task.Range = fmt.Sprintf("bytes=%d-%d", test.offset, test.offset+test.limit-1)
+			task.Limit = test.limit
+		}
+		result := ExecHTTP(&task.Task, cfg)
+		checkChunkRange(task, result)
+		if result.ErrType != test.err {
+			t.Errorf("%s: got error %d, expected %d", test.name, result.ErrType, test.err)
+		}
+		if test.err == SUCCESS && test.limit > 0 && !bytes.Equal(result.Body.Bytes(), []byte(content[test.offset:test.offset+test.limit])) {
+			t.Errorf("%s: wrong data of the range", test.name)
+		}
+	}
+}
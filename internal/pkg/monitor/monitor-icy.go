@@ -0,0 +1,174 @@
+// Icecast/SHOUTcast checks for internet radio streams.
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/hotid/streamsurfer/internal/pkg/helpers"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	icyWindow   = 5 * time.Second // audio data read during this time
+	icyMaxBytes = 1 << 20         // but no more than this
+	icyMinRate  = 0.8             // measured bitrate should be not lower than this part of advertised one
+)
+
+var icyStreamTitle = regexp.MustCompile(`StreamTitle='(.*?)';`)
+
+// Request internet radio stream with Icy-MetaData and read audio data during the short window.
+// Raw connection used because SHOUTcast servers answer with "ICY 200 OK" status line
+// which rejected by net/http client. Measured bitrate compared with advertised icy-br.
+// Headers, cookies and URL signing of the group applied as for HTTP checks.
+func ExecICY(task *Task, cfg *Config) *Result {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("trace dumped in ExecICY:", r)
+		}
+	}()
+
+	result := &Result{Task: task, Route: task.Route, Started: time.Now(), Elapsed: 0 * time.Second, ContentLength: -1}
+	uri, err := url.Parse(task.URI)
+	if err != nil || uri.Scheme != "http" && uri.Scheme != "https" {
+		result.ErrType = BADURI
+		return result
+	}
+	port := "80"
+	if uri.Scheme == "https" {
+		port = "443"
+	}
+	conn, err := dialRaw(cfg, task, uri, port, uri.Scheme == "https")
+	if err != nil {
+		result.Elapsed = time.Since(result.Started)
+		fmt.Printf("Connect failed %s: %v\n", result.Elapsed, err)
+		result.ErrType = netErrType(err)
+		if result.ErrType == BADCERT {
+			result.TLS = probeRawCert(cfg, task, uri, port)
+		}
+		return result
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(cfg.Params(task.Group).RWTimeout * time.Second))
+	req, _ := http.NewRequest("GET", task.URI, nil)
+	req.Header.Set("User-Agent", helpers.UserAgent(cfg))
+	req.Header.Set("Icy-MetaData", "1")
+	prepareRequest(cfg, task, req)
+	if task.Auth && cfg.Params(task.Group).User != "" {
+		req.SetBasicAuth(cfg.Params(task.Group).User, cfg.Params(task.Group).Pass)
+	}
+	jar := cfg.Params(task.Group).Jar
+	if jar != nil { // raw request so cookies of the group handled here instead of http.Client
+		for _, cookie := range jar.Cookies(req.URL) {
+			req.AddCookie(cookie)
+		}
+	}
+	req.Close = true
+	if err = req.Write(conn); err != nil {
+		result.Elapsed = time.Since(result.Started)
+		result.ErrType = REFUSED
+		return result
+	}
+	br := bufio.NewReader(conn)
+	status, err := br.ReadString('\n')
+	if err != nil {
+		result.Elapsed = time.Since(result.Started)
+		if isTimeout(err) {
+			result.ErrType = RTIMEOUT
+		} else {
+			result.ErrType = BODYREAD
+		}
+		return result
+	}
+	if strings.HasPrefix(status, "ICY ") { // SHOUTcast v1
+		status = "HTTP/1.0 " + status[4:]
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(io.MultiReader(strings.NewReader(status), br)), req)
+	if err != nil {
+		result.Elapsed = time.Since(result.Started)
+		result.ErrType = BADSTATUS
+		return result
+	}
+	defer resp.Body.Close()
+	if jar != nil {
+		jar.SetCookies(req.URL, resp.Cookies())
+	}
+	result.HTTPCode = resp.StatusCode
+	result.HTTPStatus = resp.Status
+	result.Headers = resp.Header
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		result.Elapsed = time.Since(result.Started)
+		result.ErrType = BADSTATUS
+		return result
+	}
+	result.ICY = &MetaICY{Name: resp.Header.Get("icy-name"), ContentType: resp.Header.Get("Content-Type")}
+	result.ICY.MetaInt, _ = strconv.Atoi(resp.Header.Get("icy-metaint"))
+	result.ICY.Bitrate, _ = strconv.Atoi(strings.TrimSpace(strings.Split(resp.Header.Get("icy-br"), ",")[0])) // some servers list several bitrates
+	started := time.Now()
+	conn.SetDeadline(started.Add(icyWindow))
+	result.RealContentLength, err = readICY(io.LimitReader(resp.Body, icyMaxBytes), result.ICY)
+	window := time.Since(started)
+	result.Elapsed = time.Since(result.Started)
+	if err == io.EOF && result.RealContentLength < icyMaxBytes { // stream closed by the server before the window ended
+		window = icyWindow
+	}
+	if result.ICY.AudioBytes > 0 && window > 0 {
+		result.ICY.Rate = float64(result.ICY.AudioBytes*8) / window.Seconds() / 1000.
+	}
+	switch {
+	case result.ICY.AudioBytes == 0:
+		result.ErrType = NODATA
+	case err != nil && err != io.EOF && !isTimeout(err):
+		result.ErrType = BODYREAD
+	case result.ICY.Bitrate > 0 && result.RealContentLength < icyMaxBytes && result.ICY.Rate < icyMinRate*float64(result.ICY.Bitrate):
+		result.ErrType = LOWBITRATE
+	}
+	return result
+}
+
+// Helper. Read audio data interleaved with metadata blocks each `icy-metaint` bytes.
+// StreamTitle of the last metadata block keeped. Returns total bytes read.
+func readICY(r io.Reader, meta *MetaICY) (int64, error) {
+	var total int64
+
+	if meta.MetaInt <= 0 { // metadata not sent
+		n, err := io.Copy(io.Discard, r)
+		meta.AudioBytes = n
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	br := bufio.NewReader(r)
+	for {
+		n, err := io.CopyN(io.Discard, br, int64(meta.MetaInt))
+		total += n
+		meta.AudioBytes += n
+		if err != nil {
+			return total, err
+		}
+		length, err := br.ReadByte()
+		if err != nil {
+			return total, err
+		}
+		total++
+		if length == 0 {
+			continue
+		}
+		block := make([]byte, int(length)*16)
+		m, err := io.ReadFull(br, block)
+		total += int64(m)
+		if err != nil {
+			return total, err
+		}
+		if title := icyStreamTitle.FindSubmatch(block); title != nil {
+			meta.StreamTitle = string(title[1])
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"testing"
+
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+)
+
+func TestReadICY(t *testing.T) {
+	meta := func(title string) string {
+		block := "StreamTitle='" + title + "';"
+		block += strings.Repeat("\x00", (16-len(block)%16)%16)
+		return string(rune(len(block)/16)) + block
+	}
+	tests := []struct {
+		name         string
+		data         string
+		metaint      int
+		audio, total int64
+		title        string
+	}{
+		{"without metadata", strings.Repeat("a", 100), 0, 100, 100, ""},
+		{"empty metadata blocks", strings.Repeat("a", 8) + "\x00" + strings.Repeat("a", 8) + "\x00" + "aaa", 8, 19, 21, ""},
+		{"the last title keeped", strings.Repeat("a", 8) + meta("First") + strings.Repeat("a", 8) + meta("Second") + "a", 8, 17, 17 + 1 + 32 + 1 + 32, "Second"},
+		{"truncated metadata", strings.Repeat("a", 8) + "\x02StreamTitle", 8, 8, 8 + 1 + 11, ""},
+	}
+	for _, test := range tests {
+		icy := &MetaICY{MetaInt: test.metaint}
+		total, _ := readICY(strings.NewReader(test.data), icy)
+		if total != test.total || icy.AudioBytes != test.audio || icy.StreamTitle != test.title {
+			t.Errorf("%s: got total %d, audio %d, title %q", test.name, total, icy.AudioBytes, icy.StreamTitle)
+		}
+	}
+}
+
+func TestExecICYRequest(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	requests := make(chan *http.Request, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			req, err := http.ReadRequest(bufio.NewReader(conn))
+			if err == nil {
+				requests <- req
+			}
+			conn.Write([]byte("ICY 200 OK\r\nicy-name: Test radio\r\nSet-Cookie: session=42\r\n\r\n"))
+			conn.Write(bytes.Repeat([]byte{0}, 1000))
+			conn.Close()
+		}
+	}()
+	jar, _ := cookiejar.New(nil)
+	cfg := testConfig(ConfigGroup{
+		Headers: map[string]string{"X-Stream": "$stream"},
+		Jar:     jar,
+		Sign:    ConfigSign{Scheme: "md5", Secret: "secret"},
+	})
+	task := testTask("http://"+ln.Addr().String()+"/radio?a=1", Route{})
+	for i := 0; i < 2; i++ {
+		result := ExecICY(task, cfg)
+		if result.ICY == nil || result.ICY.Name != "Test radio" || result.ICY.AudioBytes != 1000 {
+			t.Fatalf("check %d: got result %+v", i, result)
+		}
+		req := <-requests
+		query := req.URL.Query()
+		if req.Header.Get("X-Stream") != "test" || query.Get("a") != "1" || query.Get("token") == "" || query.Get("expires") == "" {
+			t.Errorf("check %d: got request %s %v", i, req.URL, req.Header)
+		}
+		if cookie, err := req.Cookie("session"); (i > 0) != (err == nil) || err == nil && cookie.Value != "42" {
+			t.Errorf("check %d: got cookies %v", i, req.Cookies())
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Low-Latency HLS checks for media playlists.
+package monitor
+
+import (
+	"fmt"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Blocking playlist reload should return during this number of target durations.
+const maxReloadFactor = 3.
+
+// LL-HLS tags of the media playlist (not supported by m3u8 lib).
+type llhlsTags struct {
+	partTarget     float64   // PART-TARGET of EXT-X-PART-INF
+	partHoldBack   float64   // PART-HOLD-BACK of EXT-X-SERVER-CONTROL
+	canBlockReload bool      // CAN-BLOCK-RELOAD of EXT-X-SERVER-CONTROL
+	parts          []float64 // durations of all EXT-X-PART
+	trailing       int       // parts of the segment not completed yet
+	preloadHint    string    // URI of EXT-X-PRELOAD-HINT
+}
+
+// Helper. Check LL-HLS extensions of the media playlist when `low-latency` option set for the group.
+// Part durations compared with PART-TARGET. Blocking playlist reload requested for the next part
+// when server allows it and time of the reload recorded as sub-result of the playlist.
+func probeLowLatency(cfg *Config, task *Task, result *Result) {
+	if !cfg.Params(task.Group).LowLatency || result.HLS == nil || !result.HLS.Live || result.ErrType >= CRITICAL_LEVEL {
+		return
+	}
+	tags := llhlsParse(result.Body.Bytes())
+	result.HLS.PartTarget = tags.partTarget
+	result.HLS.Parts = len(tags.parts)
+	result.HLS.CanBlockReload = tags.canBlockReload
+	result.HLS.PreloadHint = tags.preloadHint
+	checkParts(result, tags, tags.partTarget)
+	// Spec requires PART-HOLD-BACK be at least twice the part target duration.
+	if tags.partTarget > 0 && tags.partHoldBack > 0 && tags.partHoldBack < 2*tags.partTarget {
+		setErr(result, BADPART)
+	}
+	if tags.preloadHint != "" && !validSegURI(tags.preloadHint) {
+		setErr(result, BADSEGURI)
+	}
+	if tags.canBlockReload {
+		reload := probeBlockingReload(cfg, task, result, tags)
+		result.SubResults = append(result.SubResults, reload)
+		setErr(result, reload.ErrType)
+	}
+}
+
+// Helper. Request the playlist with _HLS_msn/_HLS_part directives for the part following the newest one
+// and check that the server held the request until the part appeared.
+func probeBlockingReload(cfg *Config, task *Task, result *Result, tags llhlsTags) *Result {
+	msn := result.HLS.LastSeqId + 1
+	part := -1
+	if tags.partTarget > 0 {
+		part = tags.trailing
+	}
+	reloaduri, err := url.Parse(task.URI)
+	if err != nil {
+		return &Result{Task: subTask(task, task.URI, "reload"), Route: task.Route, ErrType: BADURI, Started: time.Now()}
+	}
+	query := reloaduri.Query()
+	query.Set("_HLS_msn", strconv.FormatUint(msn, 10))
+	if part >= 0 {
+		query.Set("_HLS_part", strconv.Itoa(part))
+	}
+	reloaduri.RawQuery = query.Encode()
+	reloadtask := subTask(task, reloaduri.String(), "reload")
+	reload := ExecHTTP(reloadtask, cfg)
+	if reload.ErrType >= ERROR_LEVEL || reload.HTTPCode >= 400 {
+		setErr(reload, BADRELOAD)
+		return reload
+	}
+	if result.HLS.TargetDuration > 0 && reload.Elapsed > time.Duration(maxReloadFactor*result.HLS.TargetDuration*float64(time.Second)) {
+		setErr(reload, BADRELOAD)
+	}
+	lastSeqId, found := llhlsLastSeqId(reload.Body.Bytes())
+	next := llhlsParse(reload.Body.Bytes())
+	checkParts(reload, next, tags.partTarget)
+	switch {
+	case !found:
+		setErr(reload, BADRELOAD)
+	case lastSeqId >= msn: // requested segment completed
+	case part >= 0 && lastSeqId+1 == msn && next.trailing > part: // requested part appeared
+	default:
+		setErr(reload, BADRELOAD)
+	}
+	return reload
+}
+
+// Helper. Check durations of partial segments against the part target duration.
+func checkParts(result *Result, tags llhlsTags, partTarget float64) {
+	if len(tags.parts) > 0 && partTarget <= 0 {
+		setErr(result, BADPART)
+	}
+	for _, duration := range tags.parts {
+		if partTarget > 0 && duration > partTarget+0.001 {
+			setErr(result, BADPART)
+			return
+		}
+	}
+}
+
+// Helper. Get LL-HLS tags from the raw media playlist.
+func llhlsParse(body []byte) llhlsTags {
+	var tags llhlsTags
+
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-PART-INF:"):
+			tags.partTarget, _ = strconv.ParseFloat(hlsAttrs(line[16:])["PART-TARGET"], 64)
+		case strings.HasPrefix(line, "#EXT-X-SERVER-CONTROL:"):
+			attrs := hlsAttrs(line[22:])
+			tags.canBlockReload = attrs["CAN-BLOCK-RELOAD"] == "YES"
+			tags.partHoldBack, _ = strconv.ParseFloat(attrs["PART-HOLD-BACK"], 64)
+		case strings.HasPrefix(line, "#EXT-X-PART:"):
+			duration, _ := strconv.ParseFloat(hlsAttrs(line[12:])["DURATION"], 64)
+			tags.parts = append(tags.parts, duration)
+			tags.trailing++
+		case strings.HasPrefix(line, "#EXTINF:"):
+			tags.trailing = 0 // parts before EXTINF belong to the completed segment
+		case strings.HasPrefix(line, "#EXT-X-PRELOAD-HINT:"):
+			tags.preloadHint = hlsAttrs(line[20:])["URI"]
+		}
+	}
+	return tags
+}
+
+// Helper. Get media sequence number of the newest completed segment from the raw media playlist.
+func llhlsLastSeqId(body []byte) (uint64, bool) {
+	var seqno, segments uint64
+
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			if _, err := fmt.Sscanf(line[22:], "%d", &seqno); err != nil {
+				return 0, false
+			}
+		case strings.HasPrefix(line, "#EXTINF:"):
+			segments++
+		}
+	}
+	if segments == 0 {
+		return 0, false
+	}
+	return seqno + segments - 1, true
+}
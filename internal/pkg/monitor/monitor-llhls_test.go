@@ -0,0 +1,80 @@
+package monitor
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+)
+
+const llhlsPlaylist = `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=3.012
+#EXT-X-PART-INF:PART-TARGET=1.004
+#EXT-X-MEDIA-SEQUENCE:266
+#EXTINF:4.00008,
+fileSequence266.mp4
+#EXT-X-PART:DURATION=1.00000,INDEPENDENT=YES,URI="filePart267.0.mp4"
+#EXT-X-PART:DURATION=1.00000,URI="filePart267.1.mp4"
+#EXTINF:4.00008,
+fileSequence267.mp4
+#EXT-X-PART:DURATION=1.00000,INDEPENDENT=YES,URI="filePart268.0.mp4"
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="filePart268.1.mp4"
+`
+
+func TestLlhlsParse(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		tags llhlsTags
+	}{
+		{"no extensions", "#EXTM3U\n#EXTINF:4,\na.ts\n", llhlsTags{}},
+		{"parts", llhlsPlaylist, llhlsTags{partTarget: 1.004, partHoldBack: 3.012, canBlockReload: true, parts: []float64{1, 1, 1}, trailing: 1, preloadHint: "filePart268.1.mp4"}},
+		{"bad part duration", "#EXT-X-PART:DURATION=x,URI=\"p.mp4\"\n", llhlsTags{parts: []float64{0}, trailing: 1}},
+		{"no blocking reload", "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=NO\r\n", llhlsTags{}},
+	}
+	for _, test := range tests {
+		if tags := llhlsParse([]byte(test.body)); !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("%s: parsed as %+v", test.name, tags)
+		}
+	}
+}
+
+func TestLlhlsLastSeqId(t *testing.T) {
+	tests := []struct {
+		body  string
+		seqId uint64
+		ok    bool
+	}{
+		{llhlsPlaylist, 267, true},
+		{"#EXTM3U\n#EXTINF:4,\na.ts\n", 0, true},
+		{"#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:10\n", 0, false}, // parts only
+		{"#EXT-X-MEDIA-SEQUENCE:x\n#EXTINF:4,\na.ts\n", 0, false},
+	}
+	for _, test := range tests {
+		if seqId, ok := llhlsLastSeqId([]byte(test.body)); seqId != test.seqId || ok != test.ok {
+			t.Errorf("llhlsLastSeqId(%q) = %d, %v", test.body, seqId, ok)
+		}
+	}
+}
+
+func TestCheckParts(t *testing.T) {
+	tests := []struct {
+		name       string
+		parts      []float64
+		partTarget float64
+		err        ErrType
+	}{
+		{"no parts", nil, 0, SUCCESS},
+		{"within target", []float64{1, 1.004}, 1.004, SUCCESS},
+		{"exceeds target", []float64{1, 1.2}, 1.004, BADPART},
+		{"parts without part info", []float64{1}, 0, BADPART},
+	}
+	for _, test := range tests {
+		result := &Result{}
+		checkParts(result, llhlsTags{parts: test.parts}, test.partTarget)
+		if result.ErrType != test.err {
+			t.Errorf("%s: got error %d", test.name, result.ErrType)
+		}
+	}
+}
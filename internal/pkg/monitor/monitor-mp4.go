@@ -0,0 +1,111 @@
+// Progressive MP4 checks for the layout of top level boxes.
+package monitor
+
+import (
+	"fmt"
+	"github.com/hotid/streamsurfer/internal/pkg/media"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+)
+
+const (
+	mp4HeaderRead = 64       // bytes read at the offset of each top level box (enough for ftyp)
+	maxTopBoxes   = 32       // top level boxes after this number not walked
+	maxMoovSize   = 32 << 20 // bigger moov not downloaded
+)
+
+// Helper. Walk top level boxes of MP4 file by range requests and read tracks from moov without
+// downloading of media data. File without moov reported as broken, moov after mdat as not faststart.
+// Size of the file by boxes compared with Content-Length. Range requests appended as sub-results of the file.
+func probeProgressive(cfg *Config, task *Task, result *Result) {
+	var offset int64
+	var moovAt, mdatAt int64 = -1, -1
+
+	size := result.ContentLength // -1 when unknown, then taken from Content-Range
+	result.MP4 = &MetaMP4{}
+	for i := 0; size < 0 || offset < size; i++ {
+		if i == maxTopBoxes {
+			return
+		}
+		data, sub := readRange(cfg, task, "box", offset, mp4HeaderRead, &size)
+		result.SubResults = append(result.SubResults, sub)
+		if data == nil || size < 0 { // file size unknown, boxes can't be verified
+			setErr(result, sub.ErrType)
+			return
+		}
+		boxsize, header, err := media.BoxHeader(data)
+		if boxsize == 0 && err == nil { // box extends to the end of file
+			boxsize = size - offset
+		}
+		if err != nil || boxsize < header || offset+boxsize > size {
+			setErr(sub, BADBOX)
+			setErr(result, sub.ErrType)
+			return
+		}
+		setErr(result, sub.ErrType)
+		boxtype := string(data[4:8])
+		result.MP4.Boxes = append(result.MP4.Boxes, MetaBox{Type: boxtype, Offset: offset, Size: boxsize})
+		switch boxtype {
+		case "ftyp":
+			if boxsize <= int64(len(data)) {
+				result.MP4.Brands = media.ParseBrands(data[header:boxsize])
+			}
+		case "moov":
+			moovAt = offset
+			if boxsize > maxMoovSize {
+				break
+			}
+			moov, sub := readRange(cfg, task, "moov", offset, boxsize, &size)
+			result.SubResults = append(result.SubResults, sub)
+			if moov != nil {
+				if result.MP4.Tracks = media.ParseTracks(moov[header:]); len(result.MP4.Tracks) == 0 {
+					setErr(sub, BADBOX)
+				}
+			}
+			setErr(result, sub.ErrType)
+		case "mdat":
+			if mdatAt < 0 {
+				mdatAt = offset
+			}
+		}
+		offset += boxsize
+	}
+	result.MP4.Size = offset
+	switch {
+	case moovAt < 0:
+		setErr(result, BADBOX)
+	case mdatAt >= 0 && moovAt > mdatAt:
+		setErr(result, NOFASTSTART)
+	default:
+		result.MP4.FastStart = true
+	}
+	if result.ContentLength >= 0 && offset != result.ContentLength {
+		setErr(result, BADLENGTH)
+	}
+}
+
+// Helper. Read `length` bytes of the file from `offset` by range request. The size of the file
+// verified with Content-Range or taken from it when unknown. Returns nil data on errors.
+func readRange(cfg *Config, task *Task, kind string, offset, length int64, size *int64) ([]byte, *Result) {
+	rangetask := subTask(task, task.URI, kind)
+	rangetask.Limit = length
+	rangetask.Range = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	sub := ExecHTTP(rangetask, cfg)
+	defer sub.Body.Reset() // binary data not keeped in the history
+	if sub.ErrType >= ERROR_LEVEL || sub.HTTPCode >= 400 {
+		return nil, sub
+	}
+	_, _, total, err := parseContentRange(sub.Headers.Get("Content-Range"))
+	if sub.HTTPCode != 206 || err != nil {
+		setErr(sub, BADRANGE)
+		return nil, sub
+	}
+	switch {
+	case *size < 0:
+		*size = total
+	case total >= 0 && total != *size:
+		setErr(sub, BADLENGTH)
+	}
+	data := make([]byte, sub.Body.Len())
+	copy(data, sub.Body.Bytes())
+	return data, sub
+}
@@ -0,0 +1,71 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+)
+
+// Helper. MP4 box of the type with concatenated payloads.
+func testBox(typ string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(8+len(data)))
+	copy(header[4:], typ)
+	return append(header, data...)
+}
+
+func TestProbeProgressive(t *testing.T) {
+	ftyp := testBox("ftyp", []byte("isom"), make([]byte, 4), []byte("isomavc1"))
+	moov := testBox("moov", testBox("trak", testBox("mdia", testBox("hdlr", make([]byte, 8), []byte("vide"), make([]byte, 13)))))
+	mdat := testBox("mdat", make([]byte, 100<<10))
+
+	var mutex sync.Mutex
+	var requests []string // methods and ranges of requests
+	files := map[string][]byte{
+		"/faststart.mp4": bytes.Join([][]byte{ftyp, moov, mdat}, nil),
+		"/moovlast.mp4":  bytes.Join([][]byte{ftyp, mdat, moov}, nil),
+		"/nomoov.mp4":    bytes.Join([][]byte{ftyp, mdat}, nil),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests = append(requests, r.Method+" "+r.Header.Get("Range"))
+		mutex.Unlock()
+		http.ServeContent(w, r, "file.mp4", time.Time{}, bytes.NewReader(files[r.URL.Path]))
+	}))
+	defer srv.Close()
+	cfg := testConfig(ConfigGroup{})
+
+	tests := []struct {
+		name      string
+		path      string
+		err       ErrType
+		boxes     int
+		faststart bool
+	}{
+		{"faststart", "/faststart.mp4", SUCCESS, 3, true},
+		{"moov after mdat", "/moovlast.mp4", NOFASTSTART, 3, false},
+		{"no moov", "/nomoov.mp4", BADBOX, 2, false},
+	}
+	for _, test := range tests {
+		requests = nil
+		task := testTask(srv.URL+test.path, Route{})
+		task.HTTPMethod = "HEAD"
+		result := ExecHTTP(task, cfg)
+		probeProgressive(cfg, task, result)
+		if result.ErrType != test.err || len(result.MP4.Boxes) != test.boxes || result.MP4.FastStart != test.faststart {
+			t.Errorf("%s: got error %d, %+v", test.name, result.ErrType, result.MP4)
+		}
+		for _, req := range requests {
+			if req == "GET " {
+				t.Errorf("%s: the whole file requested", test.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Microsoft Smooth Streaming checks for client manifests and fragments.
+package monitor
+
+import (
+	"encoding/xml"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Default timescale of the manifest (100 ns units).
+const defaultSmoothTimeScale = 10000000
+
+// Smooth Streaming client manifest. Only elements required for fragment addressing parsed.
+type smoothManifest struct {
+	Duration      uint64              `xml:"Duration,attr"`
+	TimeScale     uint64              `xml:"TimeScale,attr"`
+	IsLive        string              `xml:"IsLive,attr"`
+	StreamIndexes []smoothStreamIndex `xml:"StreamIndex"`
+}
+
+type smoothStreamIndex struct {
+	Type          string               `xml:"Type,attr"`
+	Name          string               `xml:"Name,attr"`
+	Url           string               `xml:"Url,attr"`
+	TimeScale     uint64               `xml:"TimeScale,attr"`
+	QualityLevels []smoothQualityLevel `xml:"QualityLevel"`
+	Chunks        []smoothChunk        `xml:"c"`
+}
+
+type smoothQualityLevel struct {
+	Bitrate uint64 `xml:"Bitrate,attr"`
+	FourCC  string `xml:"FourCC,attr"`
+}
+
+// Fragment entry. Start time (t) of the fragment may be omitted and then it follows the previous one.
+type smoothChunk struct {
+	T *uint64 `xml:"t,attr"`
+	D uint64  `xml:"d,attr"`
+	R uint64  `xml:"r,attr"` // number of the consecutive fragments with the same duration
+}
+
+// Helper. Parse Smooth Streaming manifest from the body of the result and pass the newest fragment
+// of each quality level to media probers. Fragments appended as sub-results of the manifest.
+func probeSmooth(cfg *Config, chunktasks chan *ChunkTask, task *Task, result *Result) {
+	var manifest smoothManifest
+	var chunks []*ChunkTask
+
+	if err := xml.Unmarshal(result.Body.Bytes(), &manifest); err != nil || len(manifest.StreamIndexes) == 0 {
+		setErr(result, BADSMOOTH)
+		return
+	}
+	base, err := url.Parse(task.URI)
+	if err != nil {
+		setErr(result, BADSMOOTH)
+		return
+	}
+	timescale := manifest.TimeScale
+	if timescale == 0 {
+		timescale = defaultSmoothTimeScale
+	}
+	result.MSS = &MetaMSS{Live: strings.EqualFold(manifest.IsLive, "true")}
+	if !result.MSS.Live {
+		result.MSS.Duration = float64(manifest.Duration) / float64(timescale)
+	}
+	for _, index := range manifest.StreamIndexes {
+		lastTime, lastDuration, ok := index.lastFragment()
+		if !ok || index.Url == "" || len(index.QualityLevels) == 0 {
+			setErr(result, BADSMOOTH)
+			continue
+		}
+		scale := index.TimeScale
+		if scale == 0 {
+			scale = timescale
+		}
+		for _, level := range index.QualityLevels {
+			meta := MetaQualityLevel{Type: index.Type, Name: index.Name, Bitrate: level.Bitrate, FourCC: level.FourCC, LastTime: lastTime, Duration: float64(lastDuration) / float64(scale)}
+			if meta.LastURI, err = absURI(base, fragmentPath(index.Url, level.Bitrate, lastTime)); err != nil {
+				setErr(result, BADSMOOTH)
+				continue
+			}
+			result.MSS.QualityLevels = append(result.MSS.QualityLevels, meta)
+			if chunktasks != nil {
+				chunks = append(chunks, &ChunkTask{Task: *subTask(task, meta.LastURI, "fragment"), SeqId: lastTime, Duration: meta.Duration})
+			}
+		}
+	}
+	dispatchChunks(chunktasks, chunks, result)
+}
+
+// Helper. Get start time and duration of the newest fragment of the stream index.
+func (s *smoothStreamIndex) lastFragment() (start, duration uint64, ok bool) {
+	var next uint64
+
+	for _, c := range s.Chunks {
+		if c.T != nil {
+			next = *c.T
+		}
+		if c.D == 0 { // duration may be omitted only when the start time of the next fragment known
+			continue
+		}
+		repeat := c.R
+		if repeat == 0 {
+			repeat = 1
+		}
+		start, duration, ok = next+(repeat-1)*c.D, c.D, true
+		next += repeat * c.D
+	}
+	return start, duration, ok
+}
+
+// Helper. Substitute the bitrate and the start time into the fragment URL template of the stream index.
+func fragmentPath(template string, bitrate, start uint64) string {
+	return strings.NewReplacer(
+		"{bitrate}", strconv.FormatUint(bitrate, 10),
+		"{Bitrate}", strconv.FormatUint(bitrate, 10),
+		"{start time}", strconv.FormatUint(start, 10),
+		"{start_time}", strconv.FormatUint(start, 10),
+	).Replace(template)
+}
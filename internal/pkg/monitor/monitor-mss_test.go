@@ -0,0 +1,48 @@
+package monitor
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestSmoothLastFragment(t *testing.T) {
+	tests := []struct {
+		name            string
+		chunks          string
+		start, duration uint64
+		ok              bool
+	}{
+		{"no fragments", ``, 0, 0, false},
+		{"implicit start times", `<c d="20000000"/><c d="20000000"/><c d="10000000"/>`, 40000000, 10000000, true},
+		{"explicit start time", `<c t="1000" d="200"/><c d="200"/>`, 1200, 200, true},
+		{"repeats", `<c t="1000" d="200" r="3"/><c d="100"/>`, 1600, 100, true},
+		{"repeats of the last entry", `<c t="1000" d="200" r="3"/>`, 1400, 200, true},
+		{"start time of the next entry only", `<c t="1000" d="200"/><c t="5000"/>`, 1000, 200, true},
+		{"gap in the timeline", `<c t="1000" d="200"/><c t="5000" d="300"/>`, 5000, 300, true},
+	}
+	for _, test := range tests {
+		var index smoothStreamIndex
+		if err := xml.Unmarshal([]byte(`<StreamIndex Type="video">`+test.chunks+`</StreamIndex>`), &index); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		start, duration, ok := index.lastFragment()
+		if start != test.start || duration != test.duration || ok != test.ok {
+			t.Errorf("%s: got start %d, duration %d, ok %v", test.name, start, duration, ok)
+		}
+	}
+}
+
+func TestFragmentPath(t *testing.T) {
+	tests := []struct {
+		template, path string
+	}{
+		{"QualityLevels({bitrate})/Fragments(video={start time})", "QualityLevels(800000)/Fragments(video=40000000)"},
+		{"QualityLevels({Bitrate})/Fragments(audio={start_time})", "QualityLevels(800000)/Fragments(audio=40000000)"},
+		{"fragments/static", "fragments/static"},
+	}
+	for _, test := range tests {
+		if path := fragmentPath(test.template, 800000, 40000000); path != test.path {
+			t.Errorf("fragmentPath(%q) = %q, expected %q", test.template, path, test.path)
+		}
+	}
+}
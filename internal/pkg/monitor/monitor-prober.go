@@ -2,13 +2,14 @@
 package monitor
 
 import (
+	"errors"
 	"expvar"
 	"fmt"
 	"github.com/grafov/bcast"
 	"github.com/grafov/m3u8"
+	"github.com/hotid/streamsurfer/internal/pkg/media"
 	. "github.com/hotid/streamsurfer/internal/pkg/structures"
-	"net/url"
-	"strings"
+	"net/http"
 	"time"
 )
 
@@ -38,7 +39,6 @@ func SimpleProber(ctl *bcast.Group, tasks chan *Task, debugvars *expvar.Map, cfg
 	}
 }
 
-// TODO к реализации
 // Probe HTTP with additional checks for Widevine.
 // Really now only http-range check supported.
 func WidevineProber(ctl *bcast.Group, tasks chan *Task, debugvars *expvar.Map, cfg *Config) {
@@ -56,6 +56,9 @@ func WidevineProber(ctl *bcast.Group, tasks chan *Task, debugvars *expvar.Map, c
 		task := <-tasks
 		if time.Now().Before(task.TTL) {
 			result = ExecHTTP(task, cfg)
+			if result.ErrType < ERROR_LEVEL && result.HTTPCode < 400 {
+				probeRanges(cfg, task, result)
+			}
 			debugvars.Add("wv-tasks-done", 1)
 		} else {
 			result = TaskExpired(task)
@@ -65,10 +68,117 @@ func WidevineProber(ctl *bcast.Group, tasks chan *Task, debugvars *expvar.Map, c
 	}
 }
 
+// Probe progressive MP4 files.
+// Availability checked by HEAD. Locate top level boxes and read tracks from moov by range requests.
+func ProgressiveProber(ctl *bcast.Group, tasks chan *Task, debugvars *expvar.Map, cfg *Config) {
+	var result *Result
+
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("trace dumped in MP4 prober:", r)
+		}
+	}()
+
+	for {
+		queueCount := debugvars.Get("mp4-tasks-queue")
+		queueCount.(*expvar.Int).Set(int64(len(tasks)))
+		task := <-tasks
+		if time.Now().Before(task.TTL) {
+			result = ExecHTTP(task, cfg)
+			if result.ErrType < ERROR_LEVEL && result.HTTPCode < 400 {
+				probeProgressive(cfg, task, result)
+			}
+			debugvars.Add("mp4-tasks-done", 1)
+		} else {
+			result = TaskExpired(task)
+			debugvars.Add("mp4-tasks-expired", 1)
+		}
+		task.ReplyTo <- result
+	}
+}
+
+// Probe Icecast/SHOUTcast internet radio.
+// Read audio data during short period and check its bitrate.
+func IcyProber(ctl *bcast.Group, tasks chan *Task, debugvars *expvar.Map, cfg *Config) {
+	var result *Result
+
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("trace dumped in ICY prober:", r)
+		}
+	}()
+
+	for {
+		queueCount := debugvars.Get("icy-tasks-queue")
+		queueCount.(*expvar.Int).Set(int64(len(tasks)))
+		task := <-tasks
+		if time.Now().Before(task.TTL) {
+			result = ExecICY(task, cfg)
+			debugvars.Add("icy-tasks-done", 1)
+		} else {
+			result = TaskExpired(task)
+			debugvars.Add("icy-tasks-expired", 1)
+		}
+		task.ReplyTo <- result
+	}
+}
+
+// Probe RTSP streams.
+// Request OPTIONS and DESCRIBE and parse SDP of the stream.
+func RtspProber(ctl *bcast.Group, tasks chan *Task, debugvars *expvar.Map, cfg *Config) {
+	var result *Result
+
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("trace dumped in RTSP prober:", r)
+		}
+	}()
+
+	for {
+		queueCount := debugvars.Get("rtsp-tasks-queue")
+		queueCount.(*expvar.Int).Set(int64(len(tasks)))
+		task := <-tasks
+		if time.Now().Before(task.TTL) {
+			result = ExecRTSP(task, cfg)
+			debugvars.Add("rtsp-tasks-done", 1)
+		} else {
+			result = TaskExpired(task)
+			debugvars.Add("rtsp-tasks-expired", 1)
+		}
+		task.ReplyTo <- result
+	}
+}
+
+// Probe RTMP streams.
+// Make the handshake and connect to the application of the stream.
+func RtmpProber(ctl *bcast.Group, tasks chan *Task, debugvars *expvar.Map, cfg *Config) {
+	var result *Result
+
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("trace dumped in RTMP prober:", r)
+		}
+	}()
+
+	for {
+		queueCount := debugvars.Get("rtmp-tasks-queue")
+		queueCount.(*expvar.Int).Set(int64(len(tasks)))
+		task := <-tasks
+		if time.Now().Before(task.TTL) {
+			result = ExecRTMP(task, cfg)
+			debugvars.Add("rtmp-tasks-done", 1)
+		} else {
+			result = TaskExpired(task)
+			debugvars.Add("rtmp-tasks-expired", 1)
+		}
+		task.ReplyTo <- result
+	}
+}
+
 // HTTP Live Streaming support.
 // Parse and probe M3U8 playlists (multi- and single bitrate)
 // and report time statistics and errors
-func CupertinoProber(ctl *bcast.Group, tasks chan *Task, debugvars *expvar.Map, cfg *Config) {
+func CupertinoProber(ctl *bcast.Group, tasks chan *Task, chunktasks chan *ChunkTask, debugvars *expvar.Map, cfg *Config) {
 	var result *Result
 
 	defer func() {
@@ -90,48 +200,13 @@ func CupertinoProber(ctl *bcast.Group, tasks chan *Task, debugvars *expvar.Map,
 				} else {
 					switch listType {
 					case m3u8.MASTER:
-						m := playlist.(*m3u8.MasterPlaylist)
-						subresult := make(chan *Result, 24)
-						mainuri, err := url.Parse(task.URI)
-						if err != nil {
-							result.ErrType = UNKERR
-							goto End
-						}
-						for _, variant := range m.Variants {
-							uri, err := url.Parse(variant.URI)
-							if err != nil {
-								subresult <- &Result{Task: &Task{Tid: task.Tid, Stream: Stream{StreamKey: task.StreamKey, URI: variant.URI, Type: HLS, Name: task.Name, Title: task.Title, Group: task.Group}}, ErrType: BADURI, Started: time.Now()}
-								continue
-							}
-							var suburi string
-							if uri.IsAbs() { // absolute URI
-								suburi = variant.URI
-							} else { // relative URI
-								if variant.URI[0] == '/' { // from the root
-									suburi = fmt.Sprintf("%s://%s%s", mainuri.Scheme, mainuri.Host, variant.URI)
-								} else { // last element
-									splitted := strings.Split(task.URI, "/")
-									splitted[len(splitted)-1] = variant.URI
-									suburi = strings.Join(splitted, "/")
-								}
-							}
-							subtask := &Task{Tid: task.Tid, Stream: Stream{StreamKey: task.StreamKey, URI: suburi, Type: HLS, Name: task.Name, Title: task.Title, Group: task.Group}, ReadBody: task.ReadBody, TTL: task.TTL}
-							go func(subtask *Task) {
-								subresult <- ExecHTTP(subtask, cfg)
-							}(subtask)
-						}
-						taskCount := len(m.Variants)
-						for taskCount > 0 {
-							select {
-							case data := <-subresult:
-								result.SubResults = append(result.SubResults, data)
-							case <-time.After(60 * time.Second):
-							}
-							taskCount--
-						}
+						probeMaster(cfg, chunktasks, task, result, playlist.(*m3u8.MasterPlaylist))
 					case m3u8.MEDIA:
 						p := playlist.(*m3u8.MediaPlaylist)
-						p.Encode().String()
+						verifyHLS(cfg, task, result, p)
+						probeLowLatency(cfg, task, result)
+						state, known := task.Playlists[task.URI]
+						probeSegments(cfg, chunktasks, result, p, state, known)
 					default:
 						result.ErrType = BADFORMAT
 					}
@@ -142,7 +217,6 @@ func CupertinoProber(ctl *bcast.Group, tasks chan *Task, debugvars *expvar.Map,
 			result = TaskExpired(task)
 			debugvars.Add("hls-tasks-expired", 1)
 		}
-	End:
 		task.ReplyTo <- result
 		debugvars.Add("hls-tasks-done", 1)
 	}
@@ -150,19 +224,206 @@ func CupertinoProber(ctl *bcast.Group, tasks chan *Task, debugvars *expvar.Map,
 
 // HTTP Dynamic Streaming prober.
 // Parse and probe F4M playlists and report time statistics and errors.
-func SanjoseProber(ctl *bcast.Group, tasks chan *Task, debugvars *expvar.Map, cfg *Config) {
+func SanjoseProber(ctl *bcast.Group, tasks chan *Task, chunktasks chan *ChunkTask, debugvars *expvar.Map, cfg *Config) {
+	var result *Result
+
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("trace dumped in HDS prober:", r)
+		}
+	}()
+
 	for {
+		queueCount := debugvars.Get("hds-tasks-queue")
+		queueCount.(*expvar.Int).Set(int64(len(tasks)))
 		task := <-tasks
-		result := ExecHTTP(task, cfg)
+		if time.Now().Before(task.TTL) {
+			result = ExecHTTP(task, cfg)
+			if result.ErrType < ERROR_LEVEL && result.HTTPCode < 400 && result.RealContentLength > 0 {
+				probeManifest(cfg, chunktasks, task, result, maxManifestDepth)
+			}
+			debugvars.Add("hds-tasks-done", 1)
+		} else {
+			result = TaskExpired(task)
+			debugvars.Add("hds-tasks-expired", 1)
+		}
+		task.ReplyTo <- result
+	}
+}
+
+// MPEG-DASH prober.
+// Parse and probe MPD manifests and report time statistics and errors.
+func DashProber(ctl *bcast.Group, tasks chan *Task, chunktasks chan *ChunkTask, debugvars *expvar.Map, cfg *Config) {
+	var result *Result
+
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("trace dumped in DASH prober:", r)
+		}
+	}()
+
+	for {
+		queueCount := debugvars.Get("dash-tasks-queue")
+		queueCount.(*expvar.Int).Set(int64(len(tasks)))
+		task := <-tasks
+		if time.Now().Before(task.TTL) {
+			result = ExecHTTP(task, cfg)
+			if result.ErrType < ERROR_LEVEL && result.HTTPCode < 400 && result.RealContentLength > 0 {
+				probeMPD(cfg, chunktasks, task, result)
+			}
+			debugvars.Add("dash-tasks-done", 1)
+		} else {
+			result = TaskExpired(task)
+			debugvars.Add("dash-tasks-expired", 1)
+		}
+		task.ReplyTo <- result
+	}
+}
+
+// Microsoft Smooth Streaming prober.
+// Parse and probe Smooth Streaming manifests and report time statistics and errors.
+func RedmondProber(ctl *bcast.Group, tasks chan *Task, chunktasks chan *ChunkTask, debugvars *expvar.Map, cfg *Config) {
+	var result *Result
+
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("trace dumped in MSS prober:", r)
+		}
+	}()
+
+	for {
+		queueCount := debugvars.Get("mss-tasks-queue")
+		queueCount.(*expvar.Int).Set(int64(len(tasks)))
+		task := <-tasks
+		if time.Now().Before(task.TTL) {
+			result = ExecHTTP(task, cfg)
+			if result.ErrType < ERROR_LEVEL && result.HTTPCode < 400 && result.RealContentLength > 0 {
+				probeSmooth(cfg, chunktasks, task, result)
+			}
+			debugvars.Add("mss-tasks-done", 1)
+		} else {
+			result = TaskExpired(task)
+			debugvars.Add("mss-tasks-expired", 1)
+		}
 		task.ReplyTo <- result
-		debugvars.Add("hds-tasks-done", 1)
 	}
 }
 
 // Parse and probe media chunk
 // and report time statistics and errors
-func MediaProber(ctl *bcast.Group, streamType StreamType, taskq chan *Task, debugvars *expvar.Map) {
+func MediaProber(ctl *bcast.Group, streamType StreamType, taskq chan *ChunkTask, debugvars *expvar.Map, cfg *Config) {
+	var result *Result
+
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("trace dumped in media prober:", r)
+		}
+	}()
+
 	for {
-		time.Sleep(20 * time.Second)
+		queueCount := debugvars.Get("media-tasks-queue")
+		queueCount.(*expvar.Int).Set(int64(len(taskq)))
+		task := <-taskq
+		if time.Now().Before(task.TTL) {
+			result = ExecHTTP(&task.Task, cfg)
+			if result.Timing != nil { // time of the segment includes the download of the body
+				result.Elapsed += result.Timing.Transfer
+			}
+			if result.Elapsed > 0 {
+				result.Throughput = int64(float64(result.RealContentLength) / result.Elapsed.Seconds())
+			}
+			checkChunkRange(task, result)
+			if result.ErrType < ERROR_LEVEL && result.HTTPCode < 400 && result.RealContentLength > 0 {
+				verifyChunk(task, result)
+			}
+			result.Body.Reset() // media data not keeped in the history
+			debugvars.Add("media-tasks-done", 1)
+		} else {
+			result = TaskExpired(&task.Task)
+			debugvars.Add("media-tasks-expired", 1)
+		}
+		task.ReplyTo <- result
+	}
+}
+
+// Helper. Check the response for the segment requested by range (EXT-X-BYTERANGE):
+// 206 Partial Content expected with the body of the requested length.
+func checkChunkRange(task *ChunkTask, result *Result) {
+	if task.Range == "" || result.ErrType >= ERROR_LEVEL || result.HTTPCode >= 400 {
+		return
+	}
+	switch {
+	case result.HTTPCode != http.StatusPartialContent:
+		setErr(result, BADRANGE)
+	case result.RealContentLength != task.Limit:
+		setErr(result, BADLENGTH)
+	}
+}
+
+// Helper. Analyze container of the downloaded media segment.
+func verifyChunk(task *ChunkTask, result *Result) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("trace dumped in media parser:", r)
+		}
+	}()
+
+	data := result.Body.Bytes()
+	switch task.Method {
+	case "AES-128":
+		if task.Key == nil { // key not retrieved, problem reported for the key check
+			return
+		}
+		plain, err := media.DecryptAES128(data, task.Key, task.IV)
+		if err == nil && len(plain) == 0 { // the only block is the padding
+			err = errors.New("no data after decryption")
+		}
+		if err == nil && !(plain[0] == 0x47 || isBoxType(plain, "ftyp", "styp", "moof", "sidx", "emsg", "prft")) {
+			err = errors.New("plain data is not MPEG-TS or MP4")
+		}
+		if err != nil {
+			result.Media = &MetaMedia{Broken: true, Problems: []string{fmt.Sprintf("decryption failed: %s", err)}}
+			setErr(result, BADDECRYPT)
+			return
+		}
+		data = plain
+	}
+	switch {
+	case data[0] == 0x47: // MPEG-TS sync byte
+		result.Media = media.InspectTS(data, task.Duration)
+		switch {
+		case result.Media.Broken:
+			setErr(result, BADTS)
+		case result.Media.CCErrors > 0 || result.Media.PCRJumps > 0 || result.Media.PTSJumps > 0:
+			setErr(result, TSDISCONT)
+		}
+	case isBoxType(data, "afra", "abst"): // HDS fragment
+		result.Media = media.InspectF4F(data, task.Duration)
+		if result.Media.Broken {
+			setErr(result, BADFRAGMENT)
+		}
+	case task.Init != nil || isBoxType(data, "styp", "moof", "sidx", "emsg", "prft"):
+		result.Media = media.InspectFMP4(data, task.Init, task.Duration)
+		if result.Media.Broken {
+			setErr(result, BADBOX)
+		}
+	default:
+		return
+	}
+	if result.Media.BadDuration {
+		setErr(result, DURMISMATCH)
+	}
+}
+
+// Helper. Check the type of the first box of MP4 data.
+func isBoxType(data []byte, types ...string) bool {
+	if len(data) < 8 {
+		return false
+	}
+	for _, t := range types {
+		if string(data[4:8]) == t {
+			return true
+		}
 	}
+	return false
 }
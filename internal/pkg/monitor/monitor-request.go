@@ -0,0 +1,156 @@
+// Custom headers and URL signing of HTTP requests.
+package monitor
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"hash"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signed URLs valid for this number of seconds when `ttl` not set for the group.
+const defaultSignTTL = 300
+
+// Query parameters of the token when not set for the group.
+const (
+	defaultTokenParam   = "token"
+	defaultExpiresParam = "expires"
+)
+
+// URLSigner adds the token valid until `expires` to the request URL.
+type URLSigner func(uri *url.URL, sign ConfigSign, expires time.Time)
+
+// Signers by scheme name of `sign` option.
+var urlSigners = map[string]URLSigner{
+	"md5":         signMD5,
+	"hmac-sha1":   signHMAC(sha1.New),
+	"hmac-sha256": signHMAC(sha256.New),
+}
+
+// Add the signing scheme for groups. Should be called before monitoring started.
+func RegisterSigner(scheme string, signer URLSigner) {
+	urlSigners[scheme] = signer
+}
+
+// Helper. Set headers of the group and sign the URL of the request. Header templates
+// and signing applied to the request only so the unsigned URI of the task reported.
+func prepareRequest(cfg *Config, task *Task, req *http.Request) {
+	params := cfg.Params(task.Group)
+	for name, tmpl := range params.Headers {
+		req.Header.Set(name, expandHeader(tmpl, task, req.URL))
+	}
+	if params.Sign.Scheme == "" || !signApplied(params.Sign, task.Kind) {
+		return
+	}
+	signer, ok := urlSigners[params.Sign.Scheme]
+	if !ok {
+		fmt.Printf("Unknown sign scheme %s for group %s, request not signed.\n", params.Sign.Scheme, task.Group)
+		return
+	}
+	ttl := params.Sign.TTL
+	if ttl <= 0 {
+		ttl = defaultSignTTL
+	}
+	signer(req.URL, params.Sign, time.Now().Add(ttl*time.Second))
+}
+
+// Helper. Expand variables of the header template: $group, $stream, $title, $host, $path,
+// $uri (unsigned URL of the request), $kind (empty for top level checks), $time (unix time).
+func expandHeader(tmpl string, task *Task, uri *url.URL) string {
+	return os.Expand(tmpl, func(name string) string {
+		switch name {
+		case "group":
+			return task.Group
+		case "stream":
+			return task.Name
+		case "title":
+			return task.Title
+		case "host":
+			return uri.Host
+		case "path":
+			return uri.Path
+		case "uri":
+			return uri.String()
+		case "kind":
+			return task.Kind
+		case "time":
+			return strconv.FormatInt(time.Now().Unix(), 10)
+		default:
+			return ""
+		}
+	})
+}
+
+// Helper. Is the check signed by `apply` levels of the group (all requests when not set)?
+func signApplied(sign ConfigSign, kind string) bool {
+	if len(sign.Apply) == 0 {
+		return true
+	}
+	level := signLevel(kind)
+	for _, apply := range sign.Apply {
+		if apply == level {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper. Level of the check by its kind: master for top level URLs, segment for media
+// data (segments, fragments, init and index segments, keys, byte ranges), variant for
+// other playlists and manifests.
+func signLevel(kind string) string {
+	switch {
+	case kind == "":
+		return "master"
+	case kind == "segment", kind == "fragment", kind == "init", kind == "index", kind == "key":
+		return "segment"
+	case strings.HasPrefix(kind, "range-"):
+		return "segment"
+	default:
+		return "variant"
+	}
+}
+
+// Helper. Append the token and expiry parameters to the URL query. The original query kept
+// byte to byte as origins may sign or cache by the raw query.
+func setToken(uri *url.URL, sign ConfigSign, token string, expires time.Time) {
+	tokenParam, expiresParam := sign.TokenParam, sign.ExpiresParam
+	if tokenParam == "" {
+		tokenParam = defaultTokenParam
+	}
+	if expiresParam == "" {
+		expiresParam = defaultExpiresParam
+	}
+	params := url.QueryEscape(tokenParam) + "=" + url.QueryEscape(token) + "&" + url.QueryEscape(expiresParam) + "=" + strconv.FormatInt(expires.Unix(), 10)
+	if uri.RawQuery != "" {
+		params = uri.RawQuery + "&" + params
+	}
+	uri.RawQuery = params
+}
+
+// Token compatible with nginx secure_link_md5 "$secure_link_expires$uri $secret":
+// base64url of MD5 without padding.
+func signMD5(uri *url.URL, sign ConfigSign, expires time.Time) {
+	sum := md5.Sum([]byte(strconv.FormatInt(expires.Unix(), 10) + uri.Path + " " + sign.Secret))
+	setToken(uri, sign, base64.RawURLEncoding.EncodeToString(sum[:]), expires)
+}
+
+// Token is hex of HMAC of the expiry time followed by the path with the secret as the key.
+func signHMAC(h func() hash.Hash) URLSigner {
+	return func(uri *url.URL, sign ConfigSign, expires time.Time) {
+		mac := hmac.New(h, []byte(sign.Secret))
+		mac.Write([]byte(strconv.FormatInt(expires.Unix(), 10) + uri.Path))
+		setToken(uri, sign, hex.EncodeToString(mac.Sum(nil)), expires)
+	}
+}
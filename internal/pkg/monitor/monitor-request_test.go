@@ -0,0 +1,87 @@
+package monitor
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+)
+
+func TestSignURL(t *testing.T) {
+	expires := time.Unix(2147483647, 0)
+	tests := []struct {
+		name   string
+		uri    string
+		sign   ConfigSign
+		signed string
+	}{
+		// echo -n '2147483647/s/link secret' | openssl md5 -binary | openssl base64 | tr +/ -_ | tr -d =
+		// (nginx secure_link_md5 "$secure_link_expires$uri $secret")
+		{
+			name:   "md5 as nginx secure_link_md5",
+			uri:    "http://example.com/s/link",
+			sign:   ConfigSign{Scheme: "md5", Secret: "secret"},
+			signed: "http://example.com/s/link?token=0Xgm37lo5nFEuHMDKl_vQg&expires=2147483647",
+		},
+		{
+			name:   "md5 with custom parameter names",
+			uri:    "http://example.com/s/link",
+			sign:   ConfigSign{Scheme: "md5", Secret: "secret", TokenParam: "md5", ExpiresParam: "e"},
+			signed: "http://example.com/s/link?md5=0Xgm37lo5nFEuHMDKl_vQg&e=2147483647",
+		},
+		// echo -n '2147483647/live/index.m3u8' | openssl dgst -sha1 -hmac secret
+		{
+			name:   "hmac-sha1",
+			uri:    "https://example.com/live/index.m3u8",
+			sign:   ConfigSign{Scheme: "hmac-sha1", Secret: "secret"},
+			signed: "https://example.com/live/index.m3u8?token=d166a1ae92b5ca996f64d7a161d9052dfe719902&expires=2147483647",
+		},
+		{
+			name:   "hmac-sha256 keeps the original query",
+			uri:    "https://example.com/live/index.m3u8?z=1&a=b+c&a=%20d",
+			sign:   ConfigSign{Scheme: "hmac-sha256", Secret: "secret", TokenParam: "sig", ExpiresParam: "exp"},
+			signed: "https://example.com/live/index.m3u8?z=1&a=b+c&a=%20d&sig=a9fdbe187cbd147a94367b490aaa29e3ea661ca1367d5c2969a1591ffb0c4469&exp=2147483647",
+		},
+	}
+	for _, test := range tests {
+		uri, _ := url.Parse(test.uri)
+		urlSigners[test.sign.Scheme](uri, test.sign, expires)
+		if uri.String() != test.signed {
+			t.Errorf("%s: got %s", test.name, uri)
+		}
+	}
+}
+
+func TestPrepareRequest(t *testing.T) {
+	cfg := testConfig(ConfigGroup{
+		Headers: map[string]string{"X-Stream": "$group/$stream", "X-Kind": "$kind", "Referer": "http://$host/"},
+		Sign:    ConfigSign{Scheme: "md5", Secret: "secret", Apply: []string{"segment"}},
+	})
+	tests := []struct {
+		kind   string
+		signed bool
+	}{
+		{"", false},
+		{"media", false},
+		{"segment", true},
+		{"range-head", true},
+	}
+	for _, test := range tests {
+		task := testTask("http://example.com/live/index.m3u8?a=1", Route{})
+		task.Kind = test.kind
+		req, _ := http.NewRequest("GET", task.URI, nil)
+		prepareRequest(cfg, task, req)
+		if req.Header.Get("X-Stream") != "test/test" || req.Header.Get("X-Kind") != test.kind || req.Header.Get("Referer") != "http://example.com/" {
+			t.Errorf("kind %q: bad headers %v", test.kind, req.Header)
+		}
+		query := req.URL.Query()
+		if signed := query.Get("token") != "" && query.Get("expires") != ""; signed != test.signed || query.Get("a") != "1" {
+			t.Errorf("kind %q: got URL %s", test.kind, req.URL)
+		}
+		if task.URI != "http://example.com/live/index.m3u8?a=1" {
+			t.Errorf("kind %q: URI of the task changed to %s", test.kind, task.URI)
+		}
+	}
+}
@@ -0,0 +1,490 @@
+// RTMP checks for contribution feeds.
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	rtmpVersion       = 3
+	rtmpHandshakeSize = 1536
+	rtmpChunkSize     = 128     // default chunk size for both directions
+	rtmpMaxMessage    = 1 << 20 // bigger messages not expected during connect
+	rtmpMaxMessages   = 64      // messages skipped while waiting for the command result
+)
+
+// RTMP message types used by the probe.
+const (
+	rtmpSetChunkSize = 1
+	rtmpCommandAMF0  = 20
+)
+
+var errAMFTruncated = errors.New("truncated AMF0 data")
+
+// Property of AMF0 object. Slice of properties keeps the order of encoding.
+type amfProp struct {
+	Key   string
+	Value interface{}
+}
+
+// State of the incoming chunk stream.
+type rtmpChunkStream struct {
+	length   uint32
+	msgType  byte
+	extended bool // extended timestamp follows the chunk headers
+	payload  []byte
+}
+
+type rtmpConn struct {
+	conn        net.Conn
+	r           *bufio.Reader
+	inChunkSize int
+	streams     map[uint32]*rtmpChunkStream
+}
+
+// Make RTMP handshake and send connect and createStream commands for the application of the stream.
+// Each step appended as sub-result of the stream with its own timing.
+func ExecRTMP(task *Task, cfg *Config) *Result {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("trace dumped in ExecRTMP:", r)
+		}
+	}()
+
+	result := &Result{Task: task, Route: task.Route, Started: time.Now(), Elapsed: 0 * time.Second, ContentLength: -1}
+	uri, err := url.Parse(task.URI)
+	if err != nil || uri.Scheme != "rtmp" && uri.Scheme != "rtmps" {
+		result.ErrType = BADURI
+		return result
+	}
+	app := strings.SplitN(strings.TrimPrefix(uri.Path, "/"), "/", 2)[0]
+	if app == "" {
+		result.ErrType = BADURI
+		return result
+	}
+	port := "1935"
+	if uri.Scheme == "rtmps" {
+		port = "443"
+	}
+	conn, err := dialRaw(cfg, task, uri, port, uri.Scheme == "rtmps")
+	if err != nil {
+		result.Elapsed = time.Since(result.Started)
+		fmt.Printf("Connect failed %s: %v\n", result.Elapsed, err)
+		result.ErrType = netErrType(err)
+		if result.ErrType == BADCERT {
+			result.TLS = probeRawCert(cfg, task, uri, port)
+		}
+		return result
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(cfg.Params(task.Group).RWTimeout * time.Second))
+	defer func() { result.Elapsed = time.Since(result.Started) }()
+
+	rc := &rtmpConn{conn: conn, r: bufio.NewReader(conn), inChunkSize: rtmpChunkSize, streams: make(map[uint32]*rtmpChunkStream)}
+	result.RTMP = &MetaRTMP{App: app}
+	steps := []struct {
+		kind string
+		run  func() error
+	}{
+		{"handshake", rc.handshake},
+		{"connect", func() error {
+			return rc.connect(fmt.Sprintf("%s://%s/%s", uri.Scheme, uri.Host, app), result.RTMP)
+		}},
+		{"createstream", func() error {
+			return rc.createStream(result.RTMP)
+		}},
+	}
+	for _, step := range steps {
+		sub := &Result{Task: subTask(task, task.URI, step.kind), Route: task.Route, Started: time.Now(), ContentLength: -1}
+		if err := step.run(); err != nil {
+			fmt.Printf("RTMP %s of %s: %s\n", step.kind, task.URI, err)
+			if isTimeout(err) {
+				sub.ErrType = RTIMEOUT
+			} else {
+				sub.ErrType = BADRTMP
+			}
+		}
+		sub.Elapsed = time.Since(sub.Started)
+		result.SubResults = append(result.SubResults, sub)
+		setErr(result, sub.ErrType)
+		if sub.ErrType >= ERROR_LEVEL {
+			break
+		}
+	}
+	return result
+}
+
+// Helper. Simple handshake: C0+C1 sent, S0+S1+S2 received, S1 echoed as C2.
+func (c *rtmpConn) handshake() error {
+	c1 := make([]byte, 1+rtmpHandshakeSize)
+	c1[0] = rtmpVersion
+	binary.BigEndian.PutUint32(c1[1:], uint32(time.Now().Unix()))
+	rand.Read(c1[9:]) // zero field left between time and random data
+	if _, err := c.conn.Write(c1); err != nil {
+		return err
+	}
+	s := make([]byte, 1+2*rtmpHandshakeSize)
+	if _, err := io.ReadFull(c.r, s); err != nil {
+		return err
+	}
+	if s[0] != rtmpVersion {
+		return fmt.Errorf("unsupported RTMP version %d", s[0])
+	}
+	_, err := c.conn.Write(s[1 : 1+rtmpHandshakeSize])
+	return err
+}
+
+// Helper. Connect to the application and keep properties of the result.
+func (c *rtmpConn) connect(tcUrl string, meta *MetaRTMP) error {
+	err := c.command("connect", 1, []amfProp{
+		{"app", meta.App},
+		{"flashVer", "LNX 9,0,124,2"},
+		{"tcUrl", tcUrl},
+		{"fpad", false},
+		{"capabilities", 15.},
+		{"audioCodecs", 3191.},
+		{"videoCodecs", 252.},
+		{"videoFunction", 1.},
+	})
+	if err != nil {
+		return err
+	}
+	name, values, err := c.waitResult(1)
+	if err != nil {
+		return err
+	}
+	if len(values) > 0 {
+		if props, ok := values[0].(map[string]interface{}); ok {
+			meta.FMSVer, _ = props["fmsVer"].(string)
+		}
+	}
+	if len(values) > 1 {
+		if info, ok := values[1].(map[string]interface{}); ok {
+			meta.Code, _ = info["code"].(string)
+			meta.Description, _ = info["description"].(string)
+		}
+	}
+	if name != "_result" || meta.Code != "" && meta.Code != "NetConnection.Connect.Success" {
+		return fmt.Errorf("connect rejected: %s %s", meta.Code, meta.Description)
+	}
+	return nil
+}
+
+// Helper. Create stream on the connection and keep its id.
+func (c *rtmpConn) createStream(meta *MetaRTMP) error {
+	if err := c.command("createStream", 2, nil); err != nil {
+		return err
+	}
+	name, values, err := c.waitResult(2)
+	if err != nil {
+		return err
+	}
+	if name != "_result" || len(values) < 2 {
+		return errors.New("createStream rejected")
+	}
+	id, ok := values[1].(float64)
+	if !ok {
+		return errors.New("createStream returned no stream id")
+	}
+	meta.StreamId = id
+	return nil
+}
+
+// Helper. Send AMF0 command with the command object on the control chunk stream.
+func (c *rtmpConn) command(name string, transaction float64, object []amfProp) error {
+	var payload bytes.Buffer
+
+	amfEncode(&payload, name)
+	amfEncode(&payload, transaction)
+	if object == nil {
+		amfEncode(&payload, nil)
+	} else {
+		amfEncode(&payload, object)
+	}
+	return c.writeMessage(3, rtmpCommandAMF0, 0, payload.Bytes())
+}
+
+// Helper. Split the message to chunks of default size. The first chunk has the full header.
+func (c *rtmpConn) writeMessage(csid byte, msgType byte, streamId uint32, payload []byte) error {
+	var buf bytes.Buffer
+
+	header := []byte{csid & 0x3f, 0, 0, 0, byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), msgType, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(header[8:], streamId)
+	buf.Write(header)
+	for i := 0; i < len(payload); i += rtmpChunkSize {
+		if i > 0 {
+			buf.WriteByte(0xc0 | csid&0x3f) // continuation chunk
+		}
+		end := i + rtmpChunkSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		buf.Write(payload[i:end])
+	}
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+// Helper. Read chunks until the whole message assembled. Set Chunk Size messages applied.
+func (c *rtmpConn) readMessage() (byte, []byte, error) {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		format, csid := b>>6, uint32(b&0x3f)
+		switch csid {
+		case 0:
+			x, err := c.r.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			csid = 64 + uint32(x)
+		case 1:
+			x := make([]byte, 2)
+			if _, err := io.ReadFull(c.r, x); err != nil {
+				return 0, nil, err
+			}
+			csid = 64 + uint32(x[0]) + uint32(x[1])*256
+		}
+		cs, ok := c.streams[csid]
+		if !ok {
+			cs = new(rtmpChunkStream)
+			c.streams[csid] = cs
+		}
+		header := make([]byte, []int{11, 7, 3, 0}[format])
+		if _, err := io.ReadFull(c.r, header); err != nil {
+			return 0, nil, err
+		}
+		if format <= 2 {
+			cs.extended = header[0] == 0xff && header[1] == 0xff && header[2] == 0xff
+		}
+		if format <= 1 {
+			cs.length = uint32(header[3])<<16 | uint32(header[4])<<8 | uint32(header[5])
+			cs.msgType = header[6]
+			if cs.length > rtmpMaxMessage {
+				return 0, nil, fmt.Errorf("message too long (%d bytes)", cs.length)
+			}
+		}
+		if cs.extended {
+			if _, err := io.ReadFull(c.r, make([]byte, 4)); err != nil {
+				return 0, nil, err
+			}
+		}
+		size := int(cs.length) - len(cs.payload)
+		if size > c.inChunkSize {
+			size = c.inChunkSize
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return 0, nil, err
+		}
+		cs.payload = append(cs.payload, data...)
+		if len(cs.payload) < int(cs.length) {
+			continue
+		}
+		payload := cs.payload
+		cs.payload = nil
+		if cs.msgType == rtmpSetChunkSize && len(payload) >= 4 {
+			c.inChunkSize = int(binary.BigEndian.Uint32(payload) & 0x7fffffff)
+			if c.inChunkSize == 0 {
+				return 0, nil, errors.New("zero chunk size")
+			}
+		}
+		return cs.msgType, payload, nil
+	}
+}
+
+// Helper. Wait for _result or _error of the command with the transaction id.
+// Other messages (control, onBWDone etc.) skipped. Returns values after the transaction id.
+func (c *rtmpConn) waitResult(transaction float64) (string, []interface{}, error) {
+	for i := 0; i < rtmpMaxMessages; i++ {
+		msgType, payload, err := c.readMessage()
+		if err != nil {
+			return "", nil, err
+		}
+		if msgType != rtmpCommandAMF0 {
+			continue
+		}
+		values, err := amfDecode(payload)
+		if err != nil || len(values) < 2 {
+			return "", nil, fmt.Errorf("malformed command: %v", err)
+		}
+		name, _ := values[0].(string)
+		id, _ := values[1].(float64)
+		if (name == "_result" || name == "_error") && id == transaction {
+			return name, values[2:], nil
+		}
+	}
+	return "", nil, errors.New("no result for the command")
+}
+
+// Helper. Encode value to AMF0. Supported numbers, booleans, strings, objects and null.
+func amfEncode(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case float64:
+		buf.WriteByte(0x00)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case bool:
+		buf.WriteByte(0x01)
+		if v {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case string:
+		buf.WriteByte(0x02)
+		binary.Write(buf, binary.BigEndian, uint16(len(v)))
+		buf.WriteString(v)
+	case []amfProp:
+		buf.WriteByte(0x03)
+		for _, prop := range v {
+			binary.Write(buf, binary.BigEndian, uint16(len(prop.Key)))
+			buf.WriteString(prop.Key)
+			amfEncode(buf, prop.Value)
+		}
+		buf.Write([]byte{0, 0, 0x09}) // object end
+	default:
+		buf.WriteByte(0x05)
+	}
+}
+
+// Helper. Decode sequence of AMF0 values. Objects and ECMA arrays decoded to maps.
+func amfDecode(data []byte) ([]interface{}, error) {
+	var values []interface{}
+
+	d := &amfDecoder{data: data}
+	for d.pos < len(d.data) {
+		value, err := d.value()
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+type amfDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *amfDecoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, errAMFTruncated
+	}
+	d.pos += n
+	return d.data[d.pos-n : d.pos], nil
+}
+
+func (d *amfDecoder) str(long bool) (string, error) {
+	var size int
+
+	if long {
+		b, err := d.next(4)
+		if err != nil {
+			return "", err
+		}
+		size = int(binary.BigEndian.Uint32(b))
+	} else {
+		b, err := d.next(2)
+		if err != nil {
+			return "", err
+		}
+		size = int(binary.BigEndian.Uint16(b))
+	}
+	s, err := d.next(size)
+	return string(s), err
+}
+
+// Properties of object up to the object end marker.
+func (d *amfDecoder) props() (map[string]interface{}, error) {
+	obj := make(map[string]interface{})
+	for {
+		key, err := d.str(false)
+		if err != nil {
+			return obj, err
+		}
+		if key == "" {
+			marker, err := d.next(1)
+			if err != nil {
+				return obj, err
+			}
+			if marker[0] != 0x09 {
+				return obj, fmt.Errorf("bad AMF0 object end 0x%02x", marker[0])
+			}
+			return obj, nil
+		}
+		if obj[key], err = d.value(); err != nil {
+			return obj, err
+		}
+	}
+}
+
+func (d *amfDecoder) value() (interface{}, error) {
+	marker, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	switch marker[0] {
+	case 0x00: // number
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 0x01: // boolean
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case 0x02: // string
+		return d.str(false)
+	case 0x03: // object
+		return d.props()
+	case 0x05, 0x06: // null, undefined
+		return nil, nil
+	case 0x08: // ECMA array
+		if _, err := d.next(4); err != nil {
+			return nil, err
+		}
+		return d.props()
+	case 0x0a: // strict array
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		var list []interface{}
+		for i := binary.BigEndian.Uint32(b); i > 0; i-- {
+			value, err := d.value()
+			if err != nil {
+				return list, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case 0x0b: // date
+		b, err := d.next(10)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 0x0c: // long string
+		return d.str(true)
+	default:
+		return nil, fmt.Errorf("unsupported AMF0 type 0x%02x", marker[0])
+	}
+}
@@ -0,0 +1,173 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// Connection writing to the buffer.
+type testWriteConn struct {
+	net.Conn
+	buf *bytes.Buffer
+}
+
+func (c testWriteConn) Write(b []byte) (int, error) {
+	return c.buf.Write(b)
+}
+
+func TestAMFRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+
+	values := []interface{}{
+		"connect",
+		1.,
+		[]amfProp{{"app", "live"}, {"fpad", false}, {"capabilities", 15.}, {"empty", ""}},
+		nil,
+		true,
+	}
+	for _, value := range values {
+		amfEncode(&buf, value)
+	}
+	decoded, err := amfDecode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		"connect",
+		1.,
+		map[string]interface{}{"app": "live", "fpad": false, "capabilities": 15., "empty": ""},
+		nil,
+		true,
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("got %#v", decoded)
+	}
+}
+
+func TestAMFDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		values []interface{}
+		err    bool
+	}{
+		{"ECMA array", []byte{0x08, 0, 0, 0, 1, 0, 1, 'a', 0x05, 0, 0, 0x09}, []interface{}{map[string]interface{}{"a": nil}}, false},
+		{"strict array", []byte{0x0a, 0, 0, 0, 2, 0x01, 1, 0x02, 0, 1, 'x'}, []interface{}{[]interface{}{true, "x"}}, false},
+		{"long string", []byte{0x0c, 0, 0, 0, 2, 'o', 'k'}, []interface{}{"ok"}, false},
+		{"undefined", []byte{0x06}, []interface{}{nil}, false},
+		{"truncated number", []byte{0x00, 0x3f, 0xf0}, nil, true},
+		{"truncated string", []byte{0x02, 0, 5, 'a'}, nil, true},
+		{"bad object end", []byte{0x03, 0, 0, 0x05}, nil, true},
+		{"unsupported type", []byte{0x11}, nil, true},
+	}
+	for _, test := range tests {
+		values, err := amfDecode(test.data)
+		if (err != nil) != test.err || !test.err && !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%s: got %#v, %v", test.name, values, err)
+		}
+	}
+}
+
+func TestRTMPReadMessage(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 200)
+	short := []byte("0123456789")
+	tests := []struct {
+		name     string
+		data     [][]byte
+		messages [][]byte // payloads of the expected messages
+	}{
+		{"fmt 0 with continuation chunk", [][]byte{
+			{0x03, 0, 0, 0, 0, 0, 200, rtmpCommandAMF0, 0, 0, 0, 0}, long[:128],
+			{0xc3}, long[128:],
+		}, [][]byte{long}},
+		{"fmt 1, 2 and 3 reuse the header", [][]byte{
+			{0x03, 0, 0, 0, 0, 0, 10, rtmpCommandAMF0, 0, 0, 0, 0}, short,
+			{0x43, 0, 0, 40, 0, 0, 4, rtmpCommandAMF0}, short[:4],
+			{0x83, 0, 0, 40}, short[4:8],
+			{0xc3}, short[:4],
+		}, [][]byte{short, short[:4], short[4:8], short[:4]}},
+		{"extended timestamp", [][]byte{
+			{0x04, 0xff, 0xff, 0xff, 0, 0, 200, rtmpCommandAMF0, 1, 0, 0, 0}, {1, 2, 3, 4}, long[:128],
+			{0xc4}, {1, 2, 3, 4}, long[128:],
+		}, [][]byte{long}},
+		{"two byte chunk stream id", [][]byte{
+			{0x00, 10, 0, 0, 0, 0, 0, 10, rtmpCommandAMF0, 0, 0, 0, 0}, short,
+		}, [][]byte{short}},
+		{"set chunk size", [][]byte{
+			{0x02, 0, 0, 0, 0, 0, 4, rtmpSetChunkSize, 0, 0, 0, 0}, {0, 0, 1, 0},
+			{0x03, 0, 0, 0, 0, 0, 200, rtmpCommandAMF0, 0, 0, 0, 0}, long,
+		}, [][]byte{{0, 0, 1, 0}, long}},
+	}
+	for _, test := range tests {
+		c := &rtmpConn{r: bufio.NewReader(bytes.NewReader(bytes.Join(test.data, nil))), inChunkSize: rtmpChunkSize, streams: make(map[uint32]*rtmpChunkStream)}
+		for i, expected := range test.messages {
+			_, payload, err := c.readMessage()
+			if err != nil || !bytes.Equal(payload, expected) {
+				t.Errorf("%s: message %d got %q, %v", test.name, i, payload, err)
+			}
+		}
+		if _, _, err := c.readMessage(); err != io.EOF {
+			t.Errorf("%s: data left after messages (%v)", test.name, err)
+		}
+	}
+}
+
+func TestRTMPWriteMessage(t *testing.T) {
+	var buf bytes.Buffer
+
+	payload := bytes.Repeat([]byte{'y'}, 3*rtmpChunkSize+1)
+	w := &rtmpConn{conn: testWriteConn{buf: &buf}}
+	if err := w.writeMessage(3, rtmpCommandAMF0, 1, payload); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 12+len(payload)+3 { // full header and three continuation chunks
+		t.Errorf("got %d bytes", buf.Len())
+	}
+	r := &rtmpConn{r: bufio.NewReader(&buf), inChunkSize: rtmpChunkSize, streams: make(map[uint32]*rtmpChunkStream)}
+	msgType, data, err := r.readMessage()
+	if err != nil || msgType != rtmpCommandAMF0 || !bytes.Equal(data, payload) {
+		t.Errorf("got message type %d of %d bytes, %v", msgType, len(data), err)
+	}
+}
+
+func TestRTMPHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		version byte
+		err     bool
+	}{
+		{"version 3", rtmpVersion, false},
+		{"unsupported version", 6, true},
+	}
+	for _, test := range tests {
+		client, server := net.Pipe()
+		done := make(chan []byte, 1)
+		go func() {
+			defer server.Close()
+			c1 := make([]byte, 1+rtmpHandshakeSize)
+			if _, err := io.ReadFull(server, c1); err != nil || c1[0] != rtmpVersion {
+				t.Errorf("%s: bad C0+C1 %v", test.name, err)
+			}
+			s := append([]byte{test.version}, bytes.Repeat([]byte{'s'}, 2*rtmpHandshakeSize)...)
+			s[1] = 1 // S1 differs from S2
+			server.Write(s)
+			c2 := make([]byte, rtmpHandshakeSize)
+			io.ReadFull(server, c2)
+			done <- c2
+		}()
+		c := &rtmpConn{conn: client, r: bufio.NewReader(client)}
+		err := c.handshake()
+		client.Close()
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		c2 := <-done
+		if !test.err && (c2[0] != 1 || !bytes.Equal(c2[1:], bytes.Repeat([]byte{'s'}, rtmpHandshakeSize-1))) {
+			t.Errorf("%s: S1 not echoed as C2", test.name)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// RTSP checks for contribution feeds.
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/hotid/streamsurfer/internal/pkg/helpers"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request OPTIONS and DESCRIBE of RTSP stream on the same connection and parse SDP from
+// the DESCRIBE response. Each request appended as sub-result of the stream. RTSP status codes
+// reported in HTTPCode as they share HTTP semantics.
+func ExecRTSP(task *Task, cfg *Config) *Result {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("trace dumped in ExecRTSP:", r)
+		}
+	}()
+
+	result := &Result{Task: task, Route: task.Route, Started: time.Now(), Elapsed: 0 * time.Second, ContentLength: -1}
+	uri, err := url.Parse(task.URI)
+	if err != nil || uri.Scheme != "rtsp" && uri.Scheme != "rtsps" {
+		result.ErrType = BADURI
+		return result
+	}
+	port := "554"
+	if uri.Scheme == "rtsps" {
+		port = "322"
+	}
+	conn, err := dialRaw(cfg, task, uri, port, uri.Scheme == "rtsps")
+	if err != nil {
+		result.Elapsed = time.Since(result.Started)
+		fmt.Printf("Connect failed %s: %v\n", result.Elapsed, err)
+		result.ErrType = netErrType(err)
+		if result.ErrType == BADCERT {
+			result.TLS = probeRawCert(cfg, task, uri, port)
+		}
+		return result
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(cfg.Params(task.Group).RWTimeout * time.Second))
+	br := bufio.NewReader(conn)
+	defer func() { result.Elapsed = time.Since(result.Started) }()
+
+	options := rtspRequest(cfg, task, conn, br, "OPTIONS", 1, nil)
+	result.SubResults = append(result.SubResults, options)
+	setErr(result, options.ErrType)
+	result.HTTPCode, result.HTTPStatus, result.Headers = options.HTTPCode, options.HTTPStatus, options.Headers
+	if options.ErrType >= ERROR_LEVEL {
+		return result
+	}
+	result.RTSP = &MetaRTSP{Server: options.Headers.Get("Server")}
+	for _, method := range strings.Split(options.Headers.Get("Public"), ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			result.RTSP.Methods = append(result.RTSP.Methods, method)
+		}
+	}
+	describe := rtspRequest(cfg, task, conn, br, "DESCRIBE", 2, map[string]string{"Accept": "application/sdp"})
+	result.SubResults = append(result.SubResults, describe)
+	setErr(result, describe.ErrType)
+	if describe.ErrType >= ERROR_LEVEL {
+		return result
+	}
+	media, ok := parseSDP(describe.Body.String())
+	result.RTSP.Media = media
+	if !ok {
+		setErr(describe, BADSDP)
+		setErr(result, BADSDP)
+	}
+	return result
+}
+
+// Helper. Send RTSP request and read the response with the body. Basic credentials taken
+// from the URL of the stream or from user/pass of the group.
+func rtspRequest(cfg *Config, task *Task, conn net.Conn, br *bufio.Reader, method string, cseq int, headers map[string]string) *Result {
+	subtask := subTask(task, task.URI, strings.ToLower(method))
+	sub := &Result{Task: subtask, Route: subtask.Route, Started: time.Now(), ContentLength: -1}
+	defer func() { sub.Elapsed = time.Since(sub.Started) }()
+
+	uri, _ := url.Parse(task.URI)
+	user := uri.User
+	uri.User = nil // credentials not sent in the request line
+	if params := cfg.Params(task.Group); user == nil && params.User != "" {
+		user = url.UserPassword(params.User, params.Pass)
+	}
+	req := fmt.Sprintf("%s %s RTSP/1.0\r\nCSeq: %d\r\nUser-Agent: %s\r\n", method, uri, cseq, helpers.UserAgent(cfg))
+	if user != nil { // credentials from the URL or of the group
+		pass, _ := user.Password()
+		authreq, _ := http.NewRequest("GET", "/", nil)
+		authreq.SetBasicAuth(user.Username(), pass)
+		req += fmt.Sprintf("Authorization: %s\r\n", authreq.Header.Get("Authorization"))
+	}
+	for key, val := range headers {
+		req += fmt.Sprintf("%s: %s\r\n", key, val)
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		sub.ErrType = REFUSED
+		return sub
+	}
+	tp := textproto.NewReader(br)
+	status, err := tp.ReadLine()
+	if err != nil {
+		if isTimeout(err) {
+			sub.ErrType = RTIMEOUT
+		} else {
+			sub.ErrType = BODYREAD
+		}
+		return sub
+	}
+	fields := strings.SplitN(status, " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "RTSP/") {
+		sub.ErrType = BADSTATUS
+		return sub
+	}
+	if sub.HTTPCode, err = strconv.Atoi(fields[1]); err != nil {
+		sub.ErrType = BADSTATUS
+		return sub
+	}
+	sub.HTTPStatus = strings.Join(fields[1:], " ")
+	mime, err := tp.ReadMIMEHeader()
+	if err != nil {
+		sub.ErrType = BODYREAD
+		return sub
+	}
+	sub.Headers = http.Header(mime)
+	if length := sub.Headers.Get("Content-Length"); length != "" {
+		if sub.ContentLength, err = strconv.ParseInt(length, 10, 64); err != nil || sub.ContentLength < 0 {
+			sub.ErrType = BADLENGTH
+			return sub
+		}
+		if sub.RealContentLength, err = io.CopyN(&sub.Body, br, sub.ContentLength); err != nil {
+			sub.ErrType = BODYREAD
+			return sub
+		}
+	}
+	if sub.HTTPCode < 200 || sub.HTTPCode >= 400 {
+		sub.ErrType = BADSTATUS
+	}
+	return sub
+}
+
+// Helper. Get media descriptions (type and encoding of the first format) from SDP.
+// SDP without version line or media descriptions is invalid.
+func parseSDP(sdp string) ([]string, bool) {
+	var media []string
+	var formats []string // formats of the current media description
+
+	lines := strings.Split(strings.ReplaceAll(sdp, "\r\n", "\n"), "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "v=0" {
+		return nil, false
+	}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			fields := strings.Fields(line[2:])
+			if len(fields) < 4 {
+				return media, false
+			}
+			formats = fields[3:]
+			media = append(media, fmt.Sprintf("%s %s/%s", fields[0], fields[2], fields[3]))
+		case strings.HasPrefix(line, "a=rtpmap:") && len(media) > 0:
+			fields := strings.Fields(line[9:])
+			if len(fields) == 2 && fields[0] == formats[0] {
+				media[len(media)-1] = fmt.Sprintf("%s %s", strings.Fields(media[len(media)-1])[0], fields[1])
+			}
+		}
+	}
+	return media, len(media) > 0
+}
@@ -0,0 +1,106 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/textproto"
+	"reflect"
+	"testing"
+
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+)
+
+const testSDP = "v=0\r\n" +
+	"o=- 1 1 IN IP4 192.0.2.1\r\n" +
+	"s=Camera\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=control:track1\r\n" +
+	"m=audio 0 RTP/AVP 97 0\r\n" +
+	"a=rtpmap:0 PCMU/8000\r\n" +
+	"a=rtpmap:97 MPEG4-GENERIC/44100/2\r\n" +
+	"m=application 0 RTP/AVP 107\r\n"
+
+func TestParseSDP(t *testing.T) {
+	tests := []struct {
+		name  string
+		sdp   string
+		media []string
+		ok    bool
+	}{
+		{"fixture", testSDP, []string{"video H264/90000", "audio MPEG4-GENERIC/44100/2", "application RTP/AVP/107"}, true},
+		{"no version", "s=Camera\r\nm=video 0 RTP/AVP 96\r\n", nil, false},
+		{"no media", "v=0\r\ns=Camera\r\n", nil, false},
+		{"short media line", "v=0\nm=video 0 RTP/AVP\n", nil, false},
+	}
+	for _, test := range tests {
+		media, ok := parseSDP(test.sdp)
+		if ok != test.ok || !reflect.DeepEqual(media, test.media) {
+			t.Errorf("%s: got %q %v", test.name, media, ok)
+		}
+	}
+}
+
+// Helper. Serve the single RTSP connection: reply to OPTIONS and DESCRIBE and pass
+// Authorization headers of the requests to the channel.
+func testRTSPServer(t *testing.T, auths chan<- string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewReader(bufio.NewReader(conn))
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			header, err := tp.ReadMIMEHeader()
+			if err != nil {
+				return
+			}
+			auths <- header.Get("Authorization")
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\n", header.Get("CSeq"))
+			if line[:8] == "DESCRIBE" {
+				fmt.Fprintf(conn, "Content-Type: application/sdp\r\nContent-Length: %d\r\n\r\n%s", len(testSDP), testSDP)
+			} else {
+				fmt.Fprint(conn, "Public: OPTIONS, DESCRIBE, SETUP, PLAY\r\n\r\n")
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestExecRTSPAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		userinfo string
+		group    ConfigGroup
+		auth     string
+	}{
+		{"no credentials", "", ConfigGroup{}, ""},
+		{"credentials of the group", "", ConfigGroup{User: "group", Pass: "secret"}, "Basic Z3JvdXA6c2VjcmV0"},
+		{"credentials of the URL", "admin:12345@", ConfigGroup{User: "group", Pass: "secret"}, "Basic YWRtaW46MTIzNDU="},
+	}
+	for _, test := range tests {
+		auths := make(chan string, 2)
+		addr := testRTSPServer(t, auths)
+		result := ExecRTSP(testTask("rtsp://"+test.userinfo+addr+"/live", Route{}), testConfig(test.group))
+		if result.ErrType != SUCCESS || result.RTSP == nil || len(result.RTSP.Media) != 3 {
+			t.Errorf("%s: got error %d, %+v", test.name, result.ErrType, result.RTSP)
+		}
+		for _, method := range []string{"OPTIONS", "DESCRIBE"} {
+			if auth := <-auths; auth != test.auth {
+				t.Errorf("%s: %s sent with Authorization %q", test.name, method, auth)
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// TLS certificate checks for HTTPS streams.
+package monitor
+
+import (
+	"crypto/tls"
+	"github.com/hotid/streamsurfer/internal/pkg/helpers"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"time"
+)
+
+// Helper. Get TLS version and the peer certificate chain of the connection.
+func metaTLS(host string, state tls.ConnectionState) *MetaTLS {
+	meta := &MetaTLS{Host: host, Version: tlsVersion(state.Version), Verified: len(state.VerifiedChains) > 0}
+	for _, cert := range state.PeerCertificates {
+		meta.Chain = append(meta.Chain, MetaCert{Subject: cert.Subject.String(), Issuer: cert.Issuer.String(), NotAfter: cert.NotAfter, DNSNames: cert.DNSNames})
+		if meta.Expires.IsZero() || cert.NotAfter.Before(meta.Expires) {
+			meta.Expires = cert.NotAfter
+		}
+	}
+	if len(state.PeerCertificates) > 0 {
+		meta.HostMatch = state.PeerCertificates[0].VerifyHostname(host) == nil
+	}
+	return meta
+}
+
+// Helper. Get the certificate chain of the host which failed verification. The request of
+// the check repeated over the same route (edge, address family, proxy) without verification
+// so expired or mismatched certificates still reported. The chain of the last handshake taken
+// so it is the host after redirects as for successful checks.
+func probeCert(cfg *Config, task *Task) *MetaTLS {
+	var meta *MetaTLS
+	var host string
+
+	method := task.HTTPMethod
+	if method == "" {
+		method = "GET"
+	}
+	req, err := http.NewRequest(method, task.URI, nil)
+	if err != nil || req.URL.Scheme != "https" {
+		return nil
+	}
+	httpcfg := routeConfig(cfg, task)
+	httpcfg.InsecureTLS = true
+	client := helpers.NewRouteClient(httpcfg, req.URL.Hostname(), task.Route.Edge)
+	client.CheckRedirect = checkRedirect
+	client.Jar = cfg.Params(task.Group).Jar
+	trace := &httptrace.ClientTrace{
+		GetConn: func(hostPort string) { host, _, _ = net.SplitHostPort(hostPort) }, // SNI not sent to IP addresses
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				meta = metaTLS(host, state)
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	req.Header.Set("User-Agent", helpers.UserAgent(cfg))
+	prepareRequest(cfg, task, req)
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close() // only the handshake needed
+	}
+	return meta
+}
+
+// Helper. The same as probeCert for raw protocols (ICY, RTSP, RTMP): handshake without
+// verification on the connection opened as for the check.
+func probeRawCert(cfg *Config, task *Task, uri *url.URL, port string) *MetaTLS {
+	conn, err := dialRaw(cfg, task, uri, port, false)
+	if err != nil {
+		return nil
+	}
+	tlsconn := tls.Client(conn, &tls.Config{ServerName: uri.Hostname(), InsecureSkipVerify: true})
+	defer tlsconn.Close()
+	tlsconn.SetDeadline(time.Now().Add(cfg.Params(task.Group).RWTimeout * time.Second))
+	if err = tlsconn.Handshake(); err != nil {
+		return nil
+	}
+	return metaTLS(uri.Hostname(), tlsconn.ConnectionState())
+}
+
+// Helper. Warn when any certificate of the chain expires in less than `cert-warning` days.
+func checkCert(cfg *Config, task *Task, result *Result) {
+	if result.TLS == nil || result.TLS.Expires.IsZero() {
+		return
+	}
+	days := cfg.Params(task.Group).CertWarning
+	if days <= 0 {
+		days = DefaultCertWarning
+	}
+	if time.Until(result.TLS.Expires) < time.Duration(days)*24*time.Hour {
+		setErr(result, CERTEXPIRES)
+	}
+}
+
+// Helper. Human readable TLS version.
+func tlsVersion(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,61 @@
+package monitor
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+)
+
+func TestProbeCert(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/stream", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	_, srvport, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	cfg := testConfig(ConfigGroup{})
+
+	tests := []struct {
+		name string
+		uri  string
+		host string // host of the certificate check
+		exec func(*Task, *Config) *Result
+	}{
+		{"HTTP", srv.URL + "/stream", "127.0.0.1", ExecHTTP},
+		{"HTTP after redirect", srv.URL + "/redirect", "127.0.0.1", ExecHTTP},
+		{"HTTP over the edge", "https://example.com:" + srvport + "/stream", "example.com", ExecHTTP},
+		{"RTSP", "rtsps://127.0.0.1:" + port + "/live", "127.0.0.1", ExecRTSP},
+	}
+	for _, test := range tests {
+		task := testTask(test.uri, Route{})
+		if uri, _ := url.Parse(test.uri); uri.Hostname() == "example.com" {
+			task.Route.Edge = "127.0.0.1"
+		}
+		result := test.exec(task, cfg)
+		if result.ErrType != BADCERT || result.TLS == nil || len(result.TLS.Chain) == 0 || result.TLS.Verified || result.TLS.Host != test.host {
+			t.Errorf("%s: got error %d, %+v", test.name, result.ErrType, result.TLS)
+		}
+	}
+}
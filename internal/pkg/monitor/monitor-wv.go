@@ -0,0 +1,89 @@
+// Widevine VOD checks for range requests to progressive files.
+package monitor
+
+import (
+	"fmt"
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"strconv"
+	"strings"
+)
+
+// Size of the byte range requested at the head, the middle and the tail of the file.
+const wvRangeSize = 64 * 1024
+
+// Helper. Request byte ranges at the head, the middle and the tail of the file. Each response should be
+// 206 Partial Content with Content-Range and Content-Length of the requested range. The size of the file
+// taken from Content-Length of the full response or from Content-Range of the head range.
+// Range checks appended as sub-results of the file.
+func probeRanges(cfg *Config, task *Task, result *Result) {
+	if !strings.Contains(strings.ToLower(result.Headers.Get("Accept-Ranges")), "bytes") {
+		setErr(result, BADRANGE)
+	}
+	size := result.ContentLength
+	for _, pos := range []string{"head", "middle", "tail"} {
+		var first int64
+		switch pos {
+		case "middle":
+			first = size / 2
+		case "tail":
+			first = size - wvRangeSize
+		}
+		if first < 0 {
+			first = 0
+		}
+		last := first + wvRangeSize - 1
+		if size > 0 && last >= size {
+			last = size - 1
+		}
+		rangetask := subTask(task, task.URI, "range-"+pos)
+		rangetask.ReadBody = false
+		rangetask.Range = fmt.Sprintf("bytes=%d-%d", first, last)
+		sub := ExecHTTP(rangetask, cfg)
+		result.SubResults = append(result.SubResults, sub)
+		if sub.ErrType >= ERROR_LEVEL || sub.HTTPCode >= 400 {
+			setErr(result, sub.ErrType)
+			return
+		}
+		rfirst, rlast, total, err := parseContentRange(sub.Headers.Get("Content-Range"))
+		if size <= 0 && total > 0 { // the size of the file unknown before the head range
+			size = total
+			if last >= size {
+				last = size - 1
+			}
+		}
+		switch {
+		case sub.HTTPCode != 206, err != nil, rfirst != first, rlast != last, sub.ContentLength >= 0 && sub.ContentLength != last-first+1:
+			setErr(sub, BADRANGE)
+		case total >= 0 && total != size:
+			setErr(sub, BADRANGE)
+		}
+		setErr(result, sub.ErrType)
+		if size <= 0 { // middle and tail can't be addressed
+			return
+		}
+	}
+}
+
+// Helper. Parse Content-Range header of the partial response ("bytes first-last/total").
+// Total is -1 when the server reports it as unknown. Unsatisfied ranges ("bytes */total")
+// and ranges outside of the total reported as errors.
+func parseContentRange(header string) (first, last, total int64, err error) {
+	var rawTotal string
+
+	if !strings.HasPrefix(header, "bytes ") {
+		return 0, 0, 0, fmt.Errorf("bad content range %q", header)
+	}
+	if _, err = fmt.Sscanf(strings.Replace(header[6:], "/", " ", 1), "%d-%d %s", &first, &last, &rawTotal); err != nil {
+		return 0, 0, 0, err
+	}
+	total = -1
+	if rawTotal != "*" {
+		if total, err = strconv.ParseInt(rawTotal, 10, 64); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	if first < 0 || last < first || total >= 0 && last >= total {
+		return 0, 0, 0, fmt.Errorf("bad content range %q", header)
+	}
+	return first, last, total, nil
+}
@@ -0,0 +1,87 @@
+package monitor
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header             string
+		first, last, total int64
+		err                bool
+	}{
+		{"bytes 0-65535/1048576", 0, 65535, 1048576, false},
+		{"bytes 100-199/*", 100, 199, -1, false},
+		{"bytes 0-0/1", 0, 0, 1, false},
+		{"bytes */1048576", 0, 0, 0, true}, // unsatisfied range of 416 response
+		{"bytes 0-65535", 0, 0, 0, true},
+		{"bytes 0-65535/", 0, 0, 0, true},
+		{"bytes 0-65535/12x", 0, 0, 0, true},
+		{"bytes 200-100/1000", 0, 0, 0, true},
+		{"bytes 0-1000/1000", 0, 0, 0, true},
+		{"bytes -1-10/100", 0, 0, 0, true},
+		{"0-65535/1048576", 0, 0, 0, true},
+		{"items 0-9/10", 0, 0, 0, true},
+		{"", 0, 0, 0, true},
+	}
+	for _, test := range tests {
+		first, last, total, err := parseContentRange(test.header)
+		if (err != nil) != test.err || first != test.first || last != test.last || total != test.total {
+			t.Errorf("%q: got %d-%d/%d, %v", test.header, first, last, total, err)
+		}
+	}
+}
+
+func TestProbeRanges(t *testing.T) {
+	var mutex sync.Mutex
+	var ranges []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(r.URL.Path[1:])
+		content := bytes.Repeat([]byte{'x'}, size)
+		if r.URL.Query().Get("chunked") != "" && r.Header.Get("Range") == "" { // size unknown before the head range
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Write(content)
+			w.(http.Flusher).Flush()
+			return
+		}
+		if r.Header.Get("Range") != "" {
+			mutex.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mutex.Unlock()
+		}
+		http.ServeContent(w, r, "file.wvm", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+	cfg := testConfig(ConfigGroup{})
+
+	tests := []struct {
+		name   string
+		path   string
+		ranges []string
+	}{
+		{"big file", "/1048576", []string{"bytes=0-65535", "bytes=524288-589823", "bytes=983040-1048575"}},
+		{"smaller than three ranges", "/100000", []string{"bytes=0-65535", "bytes=50000-99999", "bytes=34464-99999"}},
+		{"smaller than the range", "/1000", []string{"bytes=0-999", "bytes=500-999", "bytes=0-999"}},
+		{"single byte", "/1", []string{"bytes=0-0", "bytes=0-0", "bytes=0-0"}},
+		{"size from the head range", "/100000?chunked=1", []string{"bytes=0-65535", "bytes=50000-99999", "bytes=34464-99999"}},
+	}
+	for _, test := range tests {
+		ranges = nil
+		task := testTask(srv.URL+test.path, Route{})
+		result := ExecHTTP(task, cfg)
+		probeRanges(cfg, task, result)
+		if result.ErrType != SUCCESS || !reflect.DeepEqual(ranges, test.ranges) {
+			t.Errorf("%s: got error %d, ranges %q", test.name, result.ErrType, ranges)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package monitor
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"expvar"
 	"fmt"
 	"github.com/grafov/bcast"
@@ -8,9 +11,15 @@ import (
 	. "github.com/hotid/streamsurfer/internal/pkg/logging"
 	. "github.com/hotid/streamsurfer/internal/pkg/stats"
 	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+	"io"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -30,7 +39,28 @@ func StreamMonitor(cfg *Config) {
 	var queueSizeWVTasks = expvar.NewInt("wv-tasks-queue")
 	var executedWVTasks = expvar.NewInt("wv-tasks-done")
 	var expiredWVTasks = expvar.NewInt("wv-tasks-expired")
-	var hlscount, hdscount, wvcount, httpcount int
+	var queueSizeDASHTasks = expvar.NewInt("dash-tasks-queue")
+	var executedDASHTasks = expvar.NewInt("dash-tasks-done")
+	var expiredDASHTasks = expvar.NewInt("dash-tasks-expired")
+	var queueSizeMSSTasks = expvar.NewInt("mss-tasks-queue")
+	var executedMSSTasks = expvar.NewInt("mss-tasks-done")
+	var expiredMSSTasks = expvar.NewInt("mss-tasks-expired")
+	var queueSizeMP4Tasks = expvar.NewInt("mp4-tasks-queue")
+	var executedMP4Tasks = expvar.NewInt("mp4-tasks-done")
+	var expiredMP4Tasks = expvar.NewInt("mp4-tasks-expired")
+	var queueSizeICYTasks = expvar.NewInt("icy-tasks-queue")
+	var executedICYTasks = expvar.NewInt("icy-tasks-done")
+	var expiredICYTasks = expvar.NewInt("icy-tasks-expired")
+	var queueSizeRTSPTasks = expvar.NewInt("rtsp-tasks-queue")
+	var executedRTSPTasks = expvar.NewInt("rtsp-tasks-done")
+	var expiredRTSPTasks = expvar.NewInt("rtsp-tasks-expired")
+	var queueSizeRTMPTasks = expvar.NewInt("rtmp-tasks-queue")
+	var executedRTMPTasks = expvar.NewInt("rtmp-tasks-done")
+	var expiredRTMPTasks = expvar.NewInt("rtmp-tasks-expired")
+	var queueSizeMediaTasks = expvar.NewInt("media-tasks-queue")
+	var executedMediaTasks = expvar.NewInt("media-tasks-done")
+	var expiredMediaTasks = expvar.NewInt("media-tasks-expired")
+	var hlscount, hdscount, wvcount, httpcount, dashcount, msscount, mp4count, icycount, rtspcount, rtmpcount int
 	var hlsprobecount int
 
 	debugvars.Set("requested-tasks", requestedTasks)
@@ -46,6 +76,27 @@ func StreamMonitor(cfg *Config) {
 	debugvars.Set("wv-tasks-queue", queueSizeWVTasks)
 	debugvars.Set("wv-tasks-done", executedWVTasks)
 	debugvars.Set("wv-tasks-expired", expiredWVTasks)
+	debugvars.Set("dash-tasks-queue", queueSizeDASHTasks)
+	debugvars.Set("dash-tasks-done", executedDASHTasks)
+	debugvars.Set("dash-tasks-expired", expiredDASHTasks)
+	debugvars.Set("mss-tasks-queue", queueSizeMSSTasks)
+	debugvars.Set("mss-tasks-done", executedMSSTasks)
+	debugvars.Set("mss-tasks-expired", expiredMSSTasks)
+	debugvars.Set("mp4-tasks-queue", queueSizeMP4Tasks)
+	debugvars.Set("mp4-tasks-done", executedMP4Tasks)
+	debugvars.Set("mp4-tasks-expired", expiredMP4Tasks)
+	debugvars.Set("icy-tasks-queue", queueSizeICYTasks)
+	debugvars.Set("icy-tasks-done", executedICYTasks)
+	debugvars.Set("icy-tasks-expired", expiredICYTasks)
+	debugvars.Set("rtsp-tasks-queue", queueSizeRTSPTasks)
+	debugvars.Set("rtsp-tasks-done", executedRTSPTasks)
+	debugvars.Set("rtsp-tasks-expired", expiredRTSPTasks)
+	debugvars.Set("rtmp-tasks-queue", queueSizeRTMPTasks)
+	debugvars.Set("rtmp-tasks-done", executedRTMPTasks)
+	debugvars.Set("rtmp-tasks-expired", expiredRTMPTasks)
+	debugvars.Set("media-tasks-queue", queueSizeMediaTasks)
+	debugvars.Set("media-tasks-done", executedMediaTasks)
+	debugvars.Set("media-tasks-expired", expiredMediaTasks)
 
 	ctl := bcast.NewGroup()
 	go Heartbeat(ctl, cfg)
@@ -54,32 +105,106 @@ func StreamMonitor(cfg *Config) {
 	for groupName, groupData := range cfg.GroupParams {
 		switch groupData.Type {
 		case HLS:
+			var gchunktasks chan *ChunkTask // segments not probed without media probers
+			if groupData.MediaProbers > 0 {
+				gchunktasks = make(chan *ChunkTask)
+			}
+			for i := 0; i < groupData.MediaProbers; i++ {
+				go MediaProber(ctl, HLS, gchunktasks, debugvars, cfg)
+			}
 			gtasks := make(chan *Task)
 			for i := 0; i < groupData.Probers; i++ {
-				go CupertinoProber(ctl, gtasks, debugvars, cfg)
+				go CupertinoProber(ctl, gtasks, gchunktasks, debugvars, cfg)
 				hlsprobecount++
 			}
-			gchunktasks := make(chan *Task)
-			for i := 0; i < groupData.MediaProbers; i++ {
-				go MediaProber(ctl, HLS, gchunktasks, debugvars)
-			}
 			for _, stream := range cfg.GroupStreams[groupName] {
 				go StreamBox(ctl, stream, HLS, gtasks, debugvars, cfg)
 				hlscount++
 			}
 		case HDS:
-			gtasks := make(chan *Task)
-			for i := 0; i < groupData.Probers; i++ {
-				go SanjoseProber(ctl, gtasks, debugvars, cfg)
+			var gchunktasks chan *ChunkTask // fragments not probed without media probers
+			if groupData.MediaProbers > 0 {
+				gchunktasks = make(chan *ChunkTask)
 			}
-			gchunktasks := make(chan *Task)
 			for i := 0; i < groupData.MediaProbers; i++ {
-				go MediaProber(ctl, HDS, gchunktasks, debugvars)
+				go MediaProber(ctl, HDS, gchunktasks, debugvars, cfg)
+			}
+			gtasks := make(chan *Task)
+			for i := 0; i < groupData.Probers; i++ {
+				go SanjoseProber(ctl, gtasks, gchunktasks, debugvars, cfg)
 			}
 			for _, stream := range cfg.GroupStreams[groupName] {
 				go StreamBox(ctl, stream, HDS, gtasks, debugvars, cfg)
 				hdscount++
 			}
+		case DASH:
+			var gchunktasks chan *ChunkTask // segments not probed without media probers
+			if groupData.MediaProbers > 0 {
+				gchunktasks = make(chan *ChunkTask)
+			}
+			for i := 0; i < groupData.MediaProbers; i++ {
+				go MediaProber(ctl, DASH, gchunktasks, debugvars, cfg)
+			}
+			gtasks := make(chan *Task)
+			for i := 0; i < groupData.Probers; i++ {
+				go DashProber(ctl, gtasks, gchunktasks, debugvars, cfg)
+			}
+			for _, stream := range cfg.GroupStreams[groupName] {
+				go StreamBox(ctl, stream, DASH, gtasks, debugvars, cfg)
+				dashcount++
+			}
+		case MSS:
+			var gchunktasks chan *ChunkTask // fragments not probed without media probers
+			if groupData.MediaProbers > 0 {
+				gchunktasks = make(chan *ChunkTask)
+			}
+			for i := 0; i < groupData.MediaProbers; i++ {
+				go MediaProber(ctl, MSS, gchunktasks, debugvars, cfg)
+			}
+			gtasks := make(chan *Task)
+			for i := 0; i < groupData.Probers; i++ {
+				go RedmondProber(ctl, gtasks, gchunktasks, debugvars, cfg)
+			}
+			for _, stream := range cfg.GroupStreams[groupName] {
+				go StreamBox(ctl, stream, MSS, gtasks, debugvars, cfg)
+				msscount++
+			}
+		case MP4:
+			gtasks := make(chan *Task)
+			for i := 0; i < groupData.Probers; i++ {
+				go ProgressiveProber(ctl, gtasks, debugvars, cfg)
+			}
+			for _, stream := range cfg.GroupStreams[groupName] {
+				go StreamBox(ctl, stream, MP4, gtasks, debugvars, cfg)
+				mp4count++
+			}
+		case ICY:
+			gtasks := make(chan *Task)
+			for i := 0; i < groupData.Probers; i++ {
+				go IcyProber(ctl, gtasks, debugvars, cfg)
+			}
+			for _, stream := range cfg.GroupStreams[groupName] {
+				go StreamBox(ctl, stream, ICY, gtasks, debugvars, cfg)
+				icycount++
+			}
+		case RTSP:
+			gtasks := make(chan *Task)
+			for i := 0; i < groupData.Probers; i++ {
+				go RtspProber(ctl, gtasks, debugvars, cfg)
+			}
+			for _, stream := range cfg.GroupStreams[groupName] {
+				go StreamBox(ctl, stream, RTSP, gtasks, debugvars, cfg)
+				rtspcount++
+			}
+		case RTMP:
+			gtasks := make(chan *Task)
+			for i := 0; i < groupData.Probers; i++ {
+				go RtmpProber(ctl, gtasks, debugvars, cfg)
+			}
+			for _, stream := range cfg.GroupStreams[groupName] {
+				go StreamBox(ctl, stream, RTMP, gtasks, debugvars, cfg)
+				rtmpcount++
+			}
 		case HTTP:
 			gtasks := make(chan *Task)
 			for i := 0; i < groupData.Probers; i++ {
@@ -133,6 +258,42 @@ func StreamMonitor(cfg *Config) {
 	} else {
 		println("No HDS monitors started.")
 	}
+	if dashcount > 0 {
+		StatsGlobals.TotalDASHMonitoringPoints = dashcount
+		fmt.Printf("%d DASH monitors started.\n", dashcount)
+	} else {
+		println("No DASH monitors started.")
+	}
+	if msscount > 0 {
+		StatsGlobals.TotalMSSMonitoringPoints = msscount
+		fmt.Printf("%d Smooth Streaming monitors started.\n", msscount)
+	} else {
+		println("No Smooth Streaming monitors started.")
+	}
+	if mp4count > 0 {
+		StatsGlobals.TotalMP4MonitoringPoints = mp4count
+		fmt.Printf("%d MP4 monitors started.\n", mp4count)
+	} else {
+		println("No MP4 monitors started.")
+	}
+	if icycount > 0 {
+		StatsGlobals.TotalICYMonitoringPoints = icycount
+		fmt.Printf("%d internet radio monitors started.\n", icycount)
+	} else {
+		println("No internet radio monitors started.")
+	}
+	if rtspcount > 0 {
+		StatsGlobals.TotalRTSPMonitoringPoints = rtspcount
+		fmt.Printf("%d RTSP monitors started.\n", rtspcount)
+	} else {
+		println("No RTSP monitors started.")
+	}
+	if rtmpcount > 0 {
+		StatsGlobals.TotalRTMPMonitoringPoints = rtmpcount
+		fmt.Printf("%d RTMP monitors started.\n", rtmpcount)
+	} else {
+		println("No RTMP monitors started.")
+	}
 	if httpcount > 0 {
 		StatsGlobals.TotalHTTPMonitoringPoints = httpcount
 		fmt.Printf("%d HTTP monitors started.\n", httpcount)
@@ -146,7 +307,7 @@ func StreamMonitor(cfg *Config) {
 		println("No Widevine monitors started.")
 	}
 	go ctl.Broadcast(0)
-	StatsGlobals.TotalMonitoringPoints = hlscount + hdscount + httpcount + wvcount
+	StatsGlobals.TotalMonitoringPoints = hlscount + hdscount + httpcount + wvcount + dashcount + msscount + mp4count + icycount + rtspcount + rtmpcount
 }
 
 // Мониторинг и статистика групп потоков.
@@ -162,6 +323,9 @@ func StreamBox(ctl *bcast.Group, stream Stream, streamType StreamType, taskq cha
 	var command Command
 	var online bool = false
 	var stats Stats
+	var playlists = make(map[string]PlaylistState) // live media playlists state by URI
+	var routes []Route                             // routes of the stream not probed yet in this round
+	var familyErrs = make(map[string]ErrType)      // the last error of the stream by address family
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -169,7 +333,7 @@ func StreamBox(ctl *bcast.Group, stream Stream, streamType StreamType, taskq cha
 		}
 	}()
 
-	task := &Task{Stream: stream, ReplyTo: make(chan *Result)}
+	task := &Task{Stream: stream, ReplyTo: make(chan *Result), Playlists: playlists}
 	switch streamType {
 	case HTTP:
 		task.ReadBody = false
@@ -177,11 +341,24 @@ func StreamBox(ctl *bcast.Group, stream Stream, streamType StreamType, taskq cha
 		task.ReadBody = true
 	case HDS:
 		task.ReadBody = true
+	case DASH:
+		task.ReadBody = true
+	case MSS:
+		task.ReadBody = true
 	case WV:
 		task.ReadBody = false
+	case MP4:
+		task.ReadBody = false
+		task.HTTPMethod = "HEAD" // only availability and size of the file, boxes read by range requests
+	case ICY:
+		task.ReadBody = false
 	default:
 		task.ReadBody = false
 	}
+	switch streamType { // manifests always requested with GET
+	case HTTP, WV:
+		setMethod(cfg.Params(stream.Group), task)
+	}
 	ctlrcv := ctl.Join() // управление мониторингом
 	timer := time.Tick(3 * time.Second)
 
@@ -205,6 +382,10 @@ func StreamBox(ctl *bcast.Group, stream Stream, streamType StreamType, taskq cha
 			max = int(cfg.Params(stream.Group).TimeBetweenTasks)
 			min = int(cfg.Params(stream.Group).TimeBetweenTasks / 4. * 3.)
 			time.Sleep(time.Duration(rand.Intn(max-min)+min)*time.Second + addSleepToBrokenStream) // randomize streams order
+			if len(routes) == 0 { // new round of address families and edges
+				routes = planRoutes(cfg.Params(stream.Group), stream.URI)
+			}
+			task.Route, routes = routes[0], routes[1:]
 			tid++
 			task.Tid = tid
 			task.TTL = time.Now().Add(time.Duration(cfg.Params(stream.Group).TaskTTL * time.Second))
@@ -221,18 +402,25 @@ func StreamBox(ctl *bcast.Group, stream Stream, streamType StreamType, taskq cha
 				}
 			}
 
-			for _, subres := range result.SubResults {
-				subres.Pid = result
-				go SaveResult(stream, *subres)
+			switch streamType {
+			case HLS:
+				checkStale(cfg, stream, playlists, result)
+			case DASH:
+				checkTimeline(cfg, stream, playlists, result)
+			}
+			if cfg.Params(stream.Group).IPFamily == "both" {
+				checkFamily(familyErrs, task.Route.Family, result)
 			}
-			go SaveResult(stream, *result)
+			stats.FamilyFail = result.FamilyFail
+
+			saveResults(stream, result)
 
 			max = int(cfg.Params(stream.Group).CheckBrokenTime)
 			min = int(cfg.Params(stream.Group).CheckBrokenTime / 4. * 3.)
 
 			switch {
 			// permanent error, not a timeout:
-			case result.ErrType > CRITICAL_LEVEL, result.ErrType == TTLEXPIRED:
+			case backoffErr(result.ErrType):
 				addSleepToBrokenStream = time.Duration(rand.Intn(max-min)+min) * time.Second
 			// works ok:
 			case result.ErrType == SUCCESS:
@@ -245,10 +433,11 @@ func StreamBox(ctl *bcast.Group, stream Stream, streamType StreamType, taskq cha
 				if result.ErrType >= WARNING_LEVEL {
 					go Log(ERROR, stream, *result)
 				} else {
-					if result.Elapsed >= cfg.Params(stream.Group).VerySlowWarningTimeout*time.Second {
+					elapsed := slowPhase(cfg.Params(stream.Group).SlowPhase, result)
+					if elapsed >= cfg.Params(stream.Group).VerySlowWarningTimeout*time.Second {
 						result.ErrType = VERYSLOW
 						go Log(WARNING, stream, *result)
-					} else if result.Elapsed >= cfg.Params(stream.Group).SlowWarningTimeout*time.Second {
+					} else if elapsed >= cfg.Params(stream.Group).SlowWarningTimeout*time.Second {
 						result.ErrType = SLOW
 						go Log(WARNING, stream, *result)
 					}
@@ -258,6 +447,15 @@ func StreamBox(ctl *bcast.Group, stream Stream, streamType StreamType, taskq cha
 	}
 }
 
+// Helper. Save the result with all nested results.
+func saveResults(stream Stream, result *Result) {
+	for _, subres := range result.SubResults {
+		subres.Pid = result
+		saveResults(stream, subres)
+	}
+	go SaveResult(stream, *result)
+}
+
 // Check & report internet availability. Stop all probers when sample internet resources not available.
 // Refs to config option ``samples``.
 func Heartbeat(ctl *bcast.Group, cfg *Config) {
@@ -299,12 +497,119 @@ func Heartbeat(ctl *bcast.Group, cfg *Config) {
 
 // Helper for expired tasks. Return result with TTL Expired status.
 func TaskExpired(task *Task) *Result {
-	result := &Result{Started: time.Now(), Elapsed: 0 * time.Second}
+	result := &Result{Task: task, Route: task.Route, Started: time.Now(), Elapsed: 0 * time.Second}
 	result.ContentLength = -1
 	result.ErrType = TTLEXPIRED
 	return result
 }
 
+// Helper. Routes of the stream checked in turn: each address family of `ip-family`,
+// each edge of the family when `edge-probe` set and each connection mode of `conn-pool`.
+// Neither families nor edges can be selected through the proxy. At least one route returned.
+func planRoutes(params ConfigGroup, uri string) []Route {
+	var routes []Route
+
+	families := []string{""}
+	switch params.IPFamily {
+	case "v4", "v6":
+		families = []string{params.IPFamily}
+	case "both":
+		families = []string{"v4", "v6"}
+	}
+	if params.Proxy != nil {
+		families = []string{""}
+	}
+	pools := []string{""}
+	switch params.ConnPool {
+	case "cold", "warm":
+		pools = []string{params.ConnPool}
+	case "both":
+		pools = []string{"cold", "warm"}
+	}
+	for _, family := range families {
+		edges := []string{""}
+		if params.EdgeProbe && params.Proxy == nil {
+			if resolved := resolveEdges(uri, family); len(resolved) > 0 {
+				edges = resolved
+			}
+		}
+		for _, edge := range edges {
+			for _, pool := range pools {
+				routes = append(routes, Route{Edge: edge, Family: family, Tag: params.RouteTag, Pool: pool})
+			}
+		}
+	}
+	return routes
+}
+
+// Helper. Resolve IPv4 and IPv6 addresses of the stream host (only addresses of the family
+// when set). Nothing returned when the host is an address itself or can't be resolved
+// (the check then reports DNS error as usual).
+func resolveEdges(uri, family string) []string {
+	var edges []string
+
+	parsed, err := url.Parse(uri)
+	if err != nil || net.ParseIP(parsed.Hostname()) != nil {
+		return nil
+	}
+	addrs, err := net.LookupIP(parsed.Hostname())
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if family == "v4" && addr.To4() == nil || family == "v6" && addr.To4() != nil {
+			continue
+		}
+		edges = append(edges, addr.String())
+	}
+	return edges
+}
+
+// Helper. In dual-stack mode the check failed over one address family while the last check
+// over another family succeeded marked with the family. The error of the check kept as is.
+func checkFamily(lastErrs map[string]ErrType, family string, result *Result) {
+	lastErrs[family] = result.ErrType
+	if result.ErrType < ERROR_LEVEL {
+		return
+	}
+	for other, errtype := range lastErrs {
+		if other == family || errtype >= ERROR_LEVEL {
+			continue
+		}
+		fmt.Printf("Stream %s fails over %s only: %s\n", result.Task.Name, family, StreamErr2String(result.ErrType))
+		result.FamilyFail = family
+		return
+	}
+}
+
+// Helper. Set HTTP method of the task by `http-method` of the group. HEAD checks only the status
+// and headers (GET closed after headers used instead when the server rejects HEAD). GET reads the body up to `http-limit` bytes (partial GET) or closes the response
+// just after headers when no limit set.
+func setMethod(params ConfigGroup, task *Task) {
+	switch params.MethodHTTP {
+	case "HEAD":
+		task.HTTPMethod = "HEAD"
+		task.ReadBody = false
+	default:
+		task.HTTPMethod = "GET"
+		if params.LimitHTTP > 0 {
+			task.ReadBody = true
+			task.Limit = params.LimitHTTP
+		}
+	}
+}
+
+// Helper. HEAD response has no body so BADLENGTH can't be detected. Instead Content-Length
+// should be present. Its value not compared between checks as live playlists change in size.
+func checkHeadLength(result *Result) {
+	if result.ErrType >= ERROR_LEVEL || result.HTTPCode >= 300 {
+		return
+	}
+	if result.ContentLength < 0 {
+		setErr(result, HEADLENGTH)
+	}
+}
+
 // Helper. Execute stream check task and return result with check status.
 func ExecHTTP(task *Task, cfg *Config) *Result {
 	defer func() {
@@ -313,7 +618,7 @@ func ExecHTTP(task *Task, cfg *Config) *Result {
 		}
 	}()
 
-	result := &Result{Task: task, Started: time.Now(), Elapsed: 0 * time.Second}
+	result := &Result{Task: task, Route: task.Route, Started: time.Now(), Elapsed: 0 * time.Second}
 	if !strings.HasPrefix(task.URI, "http://") && !strings.HasPrefix(task.URI, "https://") {
 		result.ErrType = BADURI
 		result.HTTPCode = 0
@@ -321,8 +626,11 @@ func ExecHTTP(task *Task, cfg *Config) *Result {
 		result.ContentLength = -1
 		return result
 	}
-	client := helpers.NewTimeoutClient(cfg.Params(task.Group).ConnectTimeout*time.Second, cfg.Params(task.Group).RWTimeout*time.Second)
-	req, err := http.NewRequest("GET", task.URI, nil) // TODO разделить метод по проберам cfg.Params(task.Group).MethodHTTP
+	method := task.HTTPMethod
+	if method == "" {
+		method = "GET"
+	}
+	req, err := http.NewRequest(method, task.URI, nil)
 	if err != nil {
 		fmt.Println(err)
 		result.ErrType = BADURI
@@ -331,15 +639,30 @@ func ExecHTTP(task *Task, cfg *Config) *Result {
 		result.ContentLength = -1
 		return result
 	}
+	client := routeClient(cfg, task, req.URL.Hostname())
+	result.Timing = &MetaTiming{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), traceTiming(result.Timing)))
 	req.Header.Set("User-Agent", helpers.UserAgent(cfg))
+	if task.Range != "" {
+		req.Header.Set("Range", task.Range)
+	}
+	prepareRequest(cfg, task, req)
+	if task.Auth && cfg.Params(task.Group).User != "" {
+		req.SetBasicAuth(cfg.Params(task.Group).User, cfg.Params(task.Group).Pass)
+	}
 	resp, err := client.Do(req)
+	if err == nil && method == "HEAD" && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close() // HEAD not supported by the server so the check repeated by GET closed after headers
+		get := req.Clone(req.Context())
+		get.Method = "GET"
+		resp, err = client.Do(get)
+	}
 	result.Elapsed = time.Since(result.Started)
 	if err != nil {
-		fmt.Printf("Connect timeout %s: %v\n", result.Elapsed, err)
-		if result.Elapsed > cfg.Params(task.Group).ConnectTimeout*time.Second {
-			result.ErrType = CTIMEOUT
-		} else {
-			result.ErrType = REFUSED
+		fmt.Printf("Request failed %s: %v\n", result.Elapsed, err)
+		result.ErrType = netErrType(err)
+		if result.ErrType == BADCERT {
+			result.TLS = probeCert(cfg, task)
 		}
 		result.HTTPCode = 0
 		result.HTTPStatus = ""
@@ -353,27 +676,339 @@ func ExecHTTP(task *Task, cfg *Config) *Result {
 	result.HTTPStatus = resp.Status
 	result.ContentLength = resp.ContentLength
 	result.Headers = resp.Header
+	if resp.TLS != nil {
+		result.TLS = metaTLS(resp.Request.URL.Hostname(), *resp.TLS) // the host after redirects
+	}
 	if task.ReadBody {
-		result.RealContentLength, err = result.Body.ReadFrom(resp.Body)
+		var body io.Reader = resp.Body
+		if task.Limit > 0 {
+			body = io.LimitReader(resp.Body, task.Limit)
+		}
+		transfer := time.Now()
+		result.RealContentLength, err = result.Body.ReadFrom(body)
+		result.Timing.Transfer = time.Since(transfer)
 		if err != nil {
 			result.ErrType = BODYREAD
+			if errtype := netErrType(err); errtype == RTIMEOUT || errtype == CONNRESET {
+				result.ErrType = errtype
+			}
 		}
 	}
+	if task.Route.Pool == "warm" { // net/http reuses the connection only after the body read to the end
+		io.CopyN(io.Discard, resp.Body, warmDrainLimit)
+	}
 	resp.Body.Close()
-	if result.RealContentLength > 0 && result.ContentLength != result.RealContentLength {
+	if resp.Request.Method == "HEAD" { // not after the fallback to GET
+		checkHeadLength(result)
+	}
+	if result.RealContentLength > 0 && result.ContentLength >= 0 && result.ContentLength != result.RealContentLength && result.RealContentLength != task.Limit { // -1 for chunked responses
 		result.ErrType = BADLENGTH
 	}
+	checkCert(cfg, task, result)
 	return result
 }
 
-// Helper. Verify HLS specific things.
-func verifyHLS(cfg *Config, task *Task, result *Result) {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("trace dumped in HLS parser:", r)
-			result.ErrType = HLSPARSER
+// Helper. Trace the phases of HTTP request to `timing`. Time to the first byte counted from
+// the request written so it shows the delay of the origin without network setup.
+func traceTiming(timing *MetaTiming) *httptrace.ClientTrace {
+	var mutex sync.Mutex // connects to several addresses of the host may run in parallel
+	var dnsStart, connectStart, tlsStart, wrote time.Time
+
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { timing.DNS = time.Since(dnsStart) },
+		ConnectStart: func(network, addr string) {
+			mutex.Lock()
+			if connectStart.IsZero() {
+				connectStart = time.Now()
+			}
+			mutex.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mutex.Lock()
+			if err == nil && timing.Connect == 0 {
+				timing.Connect = time.Since(connectStart)
+			}
+			mutex.Unlock()
+		},
+		GotConn:              func(info httptrace.GotConnInfo) { timing.Reused = info.Reused },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { timing.TLS = time.Since(tlsStart) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+		GotFirstResponseByte: func() { timing.TTFB = time.Since(wrote) },
+	}
+}
+
+// Helper. Time of the request phase selected by `slow-phase` of the group for comparison with
+// slow thresholds. Total elapsed time used for unknown phases and checks without timing.
+func slowPhase(phase string, result *Result) time.Duration {
+	if result.Timing == nil {
+		return result.Elapsed
+	}
+	switch phase {
+	case "dns":
+		return result.Timing.DNS
+	case "connect":
+		return result.Timing.Connect
+	case "tls":
+		return result.Timing.TLS
+	case "ttfb":
+		return result.Timing.TTFB
+	case "transfer":
+		return result.Timing.Transfer
+	default:
+		return result.Elapsed
+	}
+}
+
+// Helper. Open raw TCP connection (TLS if `secure`) to the host of the URI for protocols
+// not supported by net/http. Default port used when the URI has no port. The connection
+// tunneled through the proxy of the group when set.
+func dialRaw(cfg *Config, task *Task, uri *url.URL, port string, secure bool) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+
+	host := uri.Hostname()
+	if task.Route.Edge != "" {
+		host = task.Route.Edge
+	}
+	if uri.Port() != "" {
+		port = uri.Port()
+	}
+	dialer := &net.Dialer{Timeout: cfg.Params(task.Group).ConnectTimeout * time.Second}
+	if source := cfg.Params(task.Group).SourceAddr; source != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: source}
+	}
+	if proxy := cfg.Params(task.Group).Proxy; proxy != nil {
+		conn, err = helpers.ProxyDial(dialer, proxy, net.JoinHostPort(uri.Hostname(), port))
+	} else {
+		network := familyNetwork(task.Route.Family)
+		if network == "" {
+			network = "tcp"
+		}
+		conn, err = dialer.Dial(network, net.JoinHostPort(host, port))
+	}
+	if err != nil || !secure {
+		return conn, err
+	}
+	tlsconn := tls.Client(conn, &tls.Config{ServerName: uri.Hostname()})
+	tlsconn.SetDeadline(time.Now().Add(dialer.Timeout))
+	if err = tlsconn.Handshake(); err != nil {
+		conn.Close()
+		if isTimeout(err) {
+			err = errTLSTimeout
+		}
+		return nil, err
+	}
+	tlsconn.SetDeadline(time.Time{})
+	return tlsconn, nil
+}
+
+// Warm clients unused for this time removed. Idle connections closed by the transport
+// much earlier (90 sec) so nothing reusable lost.
+const warmClientIdle = 10 * time.Minute
+
+// Unread body of warm checks drained up to this size so the connection kept for reuse.
+// Bigger bodies closed as is and the connection dropped.
+const warmDrainLimit = 64 << 10
+
+// Keep-alive clients of warm checks by group, host and route.
+var warmClients = struct {
+	sync.Mutex
+	data   map[string]*warmClient
+	pruned time.Time
+}{data: make(map[string]*warmClient)}
+
+type warmClient struct {
+	*http.Client
+	used time.Time
+}
+
+// Helper. New client for each cold check. Warm checks of the same group and route share
+// the client so connections to the host reused across checks like a real player does.
+func routeClient(cfg *Config, task *Task, host string) *http.Client {
+	if task.Route.Pool != "warm" {
+		client := helpers.NewRouteClient(routeConfig(cfg, task), host, task.Route.Edge)
+		client.CheckRedirect = checkRedirect
+		client.Jar = cfg.Params(task.Group).Jar
+		return client
+	}
+	key := strings.Join([]string{task.Group, host, task.Route.Edge, task.Route.Family}, "|")
+	warmClients.Lock()
+	defer warmClients.Unlock()
+	now := time.Now()
+	if now.Sub(warmClients.pruned) > time.Minute { // edges of hosts change so old routes not probed anymore
+		for k, client := range warmClients.data {
+			if now.Sub(client.used) > warmClientIdle {
+				client.CloseIdleConnections()
+				delete(warmClients.data, k)
+			}
+		}
+		warmClients.pruned = now
+	}
+	client, ok := warmClients.data[key]
+	if !ok {
+		httpcfg := routeConfig(cfg, task)
+		httpcfg.KeepAlive = true
+		client = &warmClient{Client: helpers.NewRouteClient(httpcfg, host, task.Route.Edge)}
+		client.CheckRedirect = checkRedirect
+		client.Jar = cfg.Params(task.Group).Jar
+		warmClients.data[key] = client
+	}
+	client.used = now
+	return client.Client
+}
+
+// Helper. Connection settings of the task by the group and the route.
+func routeConfig(cfg *Config, task *Task) *HTTPConfig {
+	return &HTTPConfig{
+		ConnectTimeout:   cfg.Params(task.Group).ConnectTimeout * time.Second,
+		ReadWriteTimeout: cfg.Params(task.Group).RWTimeout * time.Second,
+		Network:          familyNetwork(task.Route.Family), // empty for proxied groups (see planRoutes)
+		Proxy:            cfg.Params(task.Group).Proxy,
+		LocalAddr:        cfg.Params(task.Group).SourceAddr,
+	}
+}
+
+// Helper. Network of the dialer for the address family of the route.
+func familyNetwork(family string) string {
+	switch family {
+	case "v4":
+		return "tcp4"
+	case "v6":
+		return "tcp6"
+	default:
+		return ""
+	}
+}
+
+// Helper. Check for network timeout.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+var errRedirectLoop = errors.New("redirect loop")
+
+var errTLSTimeout = errors.New("TLS handshake timeout")
+
+// Helper. Stop following redirects when URL already visited or after 10 redirects
+// (the same limit as net/http has by default).
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errRedirectLoop
+	}
+	for _, prev := range via {
+		if prev.URL.String() == req.URL.String() {
+			return errRedirectLoop
+		}
+	}
+	return nil
+}
+
+// Helper. Classify network error of the request. Timeouts on connect and on read told apart
+// by the operation of failed network call, timeouts of TLS handshake reported as TLS failures.
+// Unrecognized errors reported as unknown.
+func netErrType(err error) ErrType {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	var recordErr tls.RecordHeaderError
+
+	switch {
+	case errors.Is(err, errRedirectLoop):
+		return REDIRECTLOOP
+	case errors.As(err, &dnsErr):
+		return DNSFAIL
+	case errors.As(err, &authorityErr), errors.As(err, &invalidErr), errors.As(err, &hostnameErr):
+		return BADCERT
+	case errors.As(err, &recordErr):
+		return TLSFAIL
+	// net/http reports the handshake timeout with unexported error type so it is told by the message
+	case errors.Is(err, errTLSTimeout), isTimeout(err) && strings.HasSuffix(err.Error(), "TLS handshake timeout"):
+		return TLSFAIL
+	case errors.As(err, &opErr) && (opErr.Op == "remote error" || opErr.Op == "local error"): // TLS alert sent by the server or by the client
+		return TLSFAIL
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return REFUSED
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return CONNRESET
+	// The server closed the connection: net/http and crypto/tls return io.EOF when it closed
+	// before the response or during the handshake, io.ErrUnexpectedEOF when in the middle of the body.
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return CONNRESET
+	case isTimeout(err):
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return CTIMEOUT
+		}
+		return RTIMEOUT
+	default:
+		return UNKERR
+	}
+}
+
+// Helper. Checks of the stream slowed down after permanent errors and when probers are too
+// busy (TTL expired). Unknown errors not counted as permanent as they may be transient.
+func backoffErr(errtype ErrType) bool {
+	return errtype > CRITICAL_LEVEL && errtype != UNKERR || errtype == TTLEXPIRED
+}
+
+// Helper. Keep only the heaviest error in the result.
+func setErr(result *Result, errtype ErrType) {
+	if errtype > result.ErrType {
+		result.ErrType = errtype
+	}
+}
+
+// Helper. Nested check of the `uri` (playlist, segment, key etc.) for the stream of the task.
+// Inherits the task id, TTL and the route of the parent check.
+func subTask(task *Task, uri, kind string) *Task {
+	stream := task.Stream
+	stream.URI = uri
+	return &Task{Tid: task.Tid, Stream: stream, ReadBody: true, TTL: task.TTL, Route: task.Route, Kind: kind}
+}
+
+// Helper. Send chunk tasks to the chunk probers and wait for their results (no more than
+// a minute for each). Results keeped as nested results and their errors set for the parent.
+func dispatchChunks(chunktasks chan *ChunkTask, chunks []*ChunkTask, result *Result) []*Result {
+	var replies []*Result
+
+	if len(chunks) == 0 {
+		return nil
+	}
+	replyto := make(chan *Result, len(chunks))
+	for _, chunk := range chunks {
+		chunk.ReplyTo = replyto
+	}
+	go func() {
+		for _, chunk := range chunks {
+			chunktasks <- chunk
 		}
 	}()
+	for taskCount := len(chunks); taskCount > 0; taskCount-- {
+		select {
+		case data := <-replyto:
+			result.SubResults = append(result.SubResults, data)
+			setErr(result, data.ErrType)
+			replies = append(replies, data)
+		case <-time.After(60 * time.Second):
+		}
+	}
+	return replies
+}
+
+// Helper. Make absolute URI for the playlist entry from the URI of the playlist itself.
+func absURI(base *url.URL, ref string) (string, error) {
+	uri, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	if uri.IsAbs() {
+		return ref, nil
+	}
+	return base.ResolveReference(uri).String(), nil
 }
 
 // Ограничивать число запросов в ед.времени на ip
@@ -0,0 +1,307 @@
+package monitor
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+)
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+// The same as the handshake timeout of net/http.
+type handshakeTimeoutErr struct{}
+
+func (handshakeTimeoutErr) Error() string   { return "net/http: TLS handshake timeout" }
+func (handshakeTimeoutErr) Timeout() bool   { return true }
+func (handshakeTimeoutErr) Temporary() bool { return true }
+
+func TestNetErrType(t *testing.T) {
+	request := func(err error) error { return &url.Error{Op: "Get", URL: "http://example.com/", Err: err} }
+	tests := []struct {
+		name    string
+		err     error
+		errtype ErrType
+	}{
+		{"redirect loop", request(errRedirectLoop), REDIRECTLOOP},
+		{"dns", request(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "example.com"}}), DNSFAIL},
+		{"not TLS server", request(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), TLSFAIL},
+		{"alert from server", request(&net.OpError{Op: "remote error", Err: errors.New("tls: handshake failure")}), TLSFAIL},
+		{"alert to server", request(&net.OpError{Op: "local error", Err: errors.New("tls: unexpected message")}), TLSFAIL},
+		{"refused", request(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), REFUSED},
+		{"reset", request(&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), CONNRESET},
+		{"closed before response", request(io.EOF), CONNRESET},
+		{"closed in the body", io.ErrUnexpectedEOF, CONNRESET},
+		{"connect timeout", request(&net.OpError{Op: "dial", Err: timeoutErr{}}), CTIMEOUT},
+		{"read timeout", request(&net.OpError{Op: "read", Err: timeoutErr{}}), RTIMEOUT},
+		{"TLS handshake timeout", request(handshakeTimeoutErr{}), TLSFAIL},
+		{"raw TLS handshake timeout", errTLSTimeout, TLSFAIL},
+		{"unknown", request(errors.New("something else")), UNKERR},
+		{"unknown mentioning tls", request(errors.New("tls: unrelated message")), UNKERR},
+	}
+	for _, test := range tests {
+		if errtype := netErrType(test.err); errtype != test.errtype {
+			t.Errorf("%s: got %d, expected %d", test.name, errtype, test.errtype)
+		}
+	}
+}
+
+func TestBackoffErr(t *testing.T) {
+	tests := []struct {
+		errtype ErrType
+		backoff bool
+	}{
+		{SUCCESS, false},
+		{TTLEXPIRED, true},
+		{SLOW, false},
+		{RTIMEOUT, false},
+		{BADSTATUS, true},
+		{REFUSED, true},
+		{TLSFAIL, true},
+		{UNKERR, false},
+	}
+	for _, test := range tests {
+		if backoff := backoffErr(test.errtype); backoff != test.backoff {
+			t.Errorf("error %d: got backoff %v", test.errtype, backoff)
+		}
+	}
+}
+
+// Helper. Config with the single group "test".
+func testConfig(group ConfigGroup) *Config {
+	if group.ConnectTimeout == 0 {
+		group.ConnectTimeout = 2
+	}
+	if group.RWTimeout == 0 {
+		group.RWTimeout = 2
+	}
+	return &Config{GroupParams: map[Key]*ConfigGroup{sha256.Sum256([]byte("test")): &group}}
+}
+
+// Helper. Top level task of the test group.
+func testTask(uri string, route Route) *Task {
+	task := &Task{Route: route}
+	task.URI, task.Group, task.Name = uri, "test", "test"
+	return task
+}
+
+func TestWarmReuse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := warmDrainLimit - 1
+		if strings.HasSuffix(r.URL.Path, "big") {
+			size = 64 * warmDrainLimit
+		}
+		w.Write([]byte(strings.Repeat("x", size)))
+	}))
+	defer srv.Close()
+	cfg := testConfig(ConfigGroup{})
+
+	tests := []struct {
+		name     string
+		pool     string
+		readBody bool
+		limit    int64
+		reused   bool
+	}{
+		{"cold", "cold", false, 0, false},
+		{"warm without body", "warm", false, 0, true},
+		{"warm with partial body", "warm", true, 100, true},
+		{"warm with body", "warm", true, 0, true},
+		{"warm without body too big", "warm", false, 0, false},
+	}
+	for _, test := range tests {
+		task := testTask(srv.URL+"/"+test.name, Route{Pool: test.pool})
+		task.ReadBody, task.Limit = test.readBody, test.limit
+		ExecHTTP(task, cfg)
+		result := ExecHTTP(task, cfg)
+		if result.ErrType != SUCCESS || result.Timing.Reused != test.reused {
+			t.Errorf("%s: got error %d, reused %v", test.name, result.ErrType, result.Timing.Reused)
+		}
+	}
+}
+
+func TestPlanRoutes(t *testing.T) {
+	proxy, _ := url.Parse("http://proxy.example.com:3128")
+	tests := []struct {
+		name   string
+		params ConfigGroup
+		routes []Route
+	}{
+		{"defaults", ConfigGroup{}, []Route{{}}},
+		{"single family", ConfigGroup{IPFamily: "v6", RouteTag: "dc1"}, []Route{{Family: "v6", Tag: "dc1"}}},
+		{"families and pools in turn", ConfigGroup{IPFamily: "both", ConnPool: "both"}, []Route{
+			{Family: "v4", Pool: "cold"}, {Family: "v4", Pool: "warm"}, {Family: "v6", Pool: "cold"}, {Family: "v6", Pool: "warm"},
+		}},
+		{"families ignored through proxy", ConfigGroup{IPFamily: "both", ConnPool: "warm", Proxy: proxy}, []Route{{Pool: "warm"}}},
+		{"edges of address host", ConfigGroup{EdgeProbe: true}, []Route{{}}},
+	}
+	for _, test := range tests {
+		routes := planRoutes(test.params, "http://192.0.2.1/live/stream.m3u8")
+		if !reflect.DeepEqual(routes, test.routes) {
+			t.Errorf("%s: got %+v", test.name, routes)
+		}
+	}
+}
+
+func TestSetMethod(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/nohead" && r.Method == "HEAD":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		case r.URL.Path == "/chunked": // no Content-Length
+			w.Write([]byte("data"))
+			w.(http.Flusher).Flush()
+			return
+		case r.URL.Path == "/short" && r.Method == "GET": // less data than Content-Length declares
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n" + strings.Repeat("x", 100))
+			buf.Flush()
+			conn.Close()
+			return
+		}
+		w.Header().Set("Content-Length", "1000") // HEAD responses with the length of GET body
+		if r.Method == "GET" {
+			w.Write([]byte(strings.Repeat("x", 1000)))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		params   ConfigGroup
+		path     string
+		method   string
+		readBody bool
+		err      ErrType
+	}{
+		{"HEAD", ConfigGroup{MethodHTTP: "HEAD", LimitHTTP: 100}, "/file", "HEAD", false, SUCCESS},
+		{"HEAD with mismatched Content-Length", ConfigGroup{MethodHTTP: "HEAD"}, "/short", "HEAD", false, SUCCESS},
+		{"HEAD without Content-Length", ConfigGroup{MethodHTTP: "HEAD"}, "/chunked", "HEAD", false, HEADLENGTH},
+		{"HEAD not allowed", ConfigGroup{MethodHTTP: "HEAD"}, "/nohead", "HEAD", false, SUCCESS},
+		{"GET closed after headers", ConfigGroup{}, "/short", "GET", false, SUCCESS},
+		{"GET with mismatched Content-Length", ConfigGroup{LimitHTTP: 4096}, "/short", "GET", true, BADLENGTH},
+		{"partial GET", ConfigGroup{MethodHTTP: "GET", LimitHTTP: 100}, "/file", "GET", true, SUCCESS},
+		{"GET without Content-Length", ConfigGroup{LimitHTTP: 100}, "/chunked", "GET", true, SUCCESS},
+	}
+	for _, test := range tests {
+		task := testTask(srv.URL+test.path, Route{})
+		setMethod(test.params, task)
+		if task.HTTPMethod != test.method || task.ReadBody != test.readBody {
+			t.Errorf("%s: got method %s, read body %v", test.name, task.HTTPMethod, task.ReadBody)
+		}
+		result := ExecHTTP(task, testConfig(test.params))
+		if result.ErrType != test.err || result.HTTPCode != http.StatusOK {
+			t.Errorf("%s: got error %d, status %d", test.name, result.ErrType, result.HTTPCode)
+		}
+	}
+}
+
+func TestSlowPhase(t *testing.T) {
+	timing := &MetaTiming{DNS: 1 * time.Millisecond, Connect: 2 * time.Millisecond, TLS: 3 * time.Millisecond, TTFB: 4 * time.Millisecond, Transfer: 5 * time.Millisecond}
+	tests := []struct {
+		phase   string
+		timing  *MetaTiming
+		elapsed time.Duration
+	}{
+		{"dns", timing, 1 * time.Millisecond},
+		{"connect", timing, 2 * time.Millisecond},
+		{"tls", timing, 3 * time.Millisecond},
+		{"ttfb", timing, 4 * time.Millisecond},
+		{"transfer", timing, 5 * time.Millisecond},
+		{"", timing, time.Second},
+		{"unknown", timing, time.Second},
+		{"ttfb", nil, time.Second}, // raw protocols without timing
+	}
+	for _, test := range tests {
+		result := &Result{Elapsed: time.Second, Timing: test.timing}
+		if elapsed := slowPhase(test.phase, result); elapsed != test.elapsed {
+			t.Errorf("%q: got %s, expected %s", test.phase, elapsed, test.elapsed)
+		}
+	}
+}
+
+func TestTraceTiming(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay) // the origin thinks before the response
+		w.Write([]byte("head"))
+		w.(http.Flusher).Flush()
+		time.Sleep(delay) // and sends the body slowly
+		w.Write([]byte("tail"))
+	})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	tlssrv := httptest.NewTLSServer(handler)
+	defer tlssrv.Close()
+
+	// DNS, connect, TTFB and transfer of the HTTP check
+	task := testTask(strings.Replace(srv.URL, "127.0.0.1", "localhost", 1), Route{})
+	task.ReadBody = true
+	result := ExecHTTP(task, testConfig(ConfigGroup{}))
+	timing := result.Timing
+	if result.ErrType != SUCCESS || timing.DNS <= 0 || timing.Connect <= 0 || timing.TLS != 0 || timing.Reused {
+		t.Errorf("HTTP: got error %d, timing %+v", result.ErrType, timing)
+	}
+	if timing.TTFB < delay || timing.TTFB > result.Elapsed || timing.Transfer < delay/2 { // transfer measured from the headers read
+		t.Errorf("HTTP: got TTFB %s, transfer %s, elapsed %s", timing.TTFB, timing.Transfer, result.Elapsed)
+	}
+
+	// TLS handshake measured separately, TTFB counted from the request written
+	timing = &MetaTiming{}
+	req, _ := http.NewRequest("GET", tlssrv.URL, nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), traceTiming(timing)))
+	resp, err := tlssrv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if timing.Connect <= 0 || timing.TLS <= 0 || timing.TTFB < delay {
+		t.Errorf("HTTPS: got timing %+v", timing)
+	}
+}
+
+// Connects to several addresses of the host run in parallel: the time of the first
+// successful connect counted from the first connect started.
+func TestTraceTimingParallelConnects(t *testing.T) {
+	var wg sync.WaitGroup
+
+	timing := &MetaTiming{}
+	trace := traceTiming(timing)
+	started := time.Now()
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			trace.ConnectStart("tcp", "192.0.2.1:80")
+			time.Sleep(time.Duration(i) * 5 * time.Millisecond)
+			var err error
+			if i < 4 {
+				err = syscall.ECONNREFUSED
+			}
+			trace.ConnectDone("tcp", "192.0.2.1:80", err)
+		}(i)
+	}
+	wg.Wait()
+	if timing.Connect < 20*time.Millisecond || timing.Connect >= time.Since(started) {
+		t.Errorf("got connect time %s", timing.Connect)
+	}
+}
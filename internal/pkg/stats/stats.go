@@ -14,6 +14,12 @@ var StatsGlobals = struct {
 	TotalWVMonitoringPoints   int
 	TotalHLSMonitoringPoints  int
 	TotalHDSMonitoringPoints  int
+	TotalDASHMonitoringPoints int
+	TotalMSSMonitoringPoints  int
+	TotalMP4MonitoringPoints  int
+	TotalICYMonitoringPoints  int
+	TotalRTSPMonitoringPoints int
+	TotalRTMPMonitoringPoints int
 	MonitoringState           bool // is inet available?
 }{}
 
@@ -42,6 +48,34 @@ var ErrTotalHistory = struct {
 	count map[ErrTotalHistoryKey]uint
 }{count: make(map[ErrTotalHistoryKey]uint)}
 
+// The last seen certificates by host.
+var HostCerts = struct {
+	sync.RWMutex
+	data map[string]HostCert
+}{data: make(map[string]HostCert)}
+
+// Checks by edge addresses of streams. Checks older than `db-expired` removed as the results history.
+// Persistently keeped in Redis and restored on start.
+var EdgeStats = struct {
+	sync.RWMutex
+	data map[edgeKey]*edgeChecks
+}{data: make(map[edgeKey]*edgeChecks)}
+
+type edgeKey struct {
+	Key
+	Edge string
+}
+
+type edgeChecks struct {
+	Stream
+	checks []edgeCheck // ordered by time of the check
+}
+
+type edgeCheck struct {
+	Started time.Time
+	ErrType ErrType
+}
+
 type Incident struct {
 	Id uint64
 	Key
@@ -61,6 +95,7 @@ func StatKeeper(cfg *Config) {
 	resultIn = make(chan ResultInQuery, 4096)
 	resultOut = make(chan ResultOutQuery, 8)
 	errorsOut = make(chan OutQuery, 8)
+	restoreEdges(cfg)
 
 	// storage maintainance period
 	///timer := time.Tick(12 * time.Second)
@@ -80,6 +115,14 @@ func StatKeeper(cfg *Config) {
 		case state := <-resultIn: // incoming results from streamboxes
 			//results[Key{state.Stream.Group, state.Stream.Name}] = append(results[Key{state.Stream.Group, state.Stream.Name}], state.Last)
 			storage.RedKeepResult(state.Stream.StreamKey, state.Last.Started, state.Last)
+			if state.Last.TLS != nil {
+				SaveCert(state.Stream, state.Last.Started, *state.Last.TLS)
+			}
+			if state.Last.Pid == nil && state.Last.Route.Edge != "" {
+				check := KeepedEdge{Edge: state.Last.Route.Edge, Started: state.Last.Started, ErrType: state.Last.ErrType}
+				SaveEdge(state.Stream, check)
+				storage.RedKeepEdge(state.Stream.StreamKey, check)
+			}
 			if state.Last.ErrType > WARNING_LEVEL {
 				storage.RedKeepError(state.Stream.StreamKey, state.Last.Started, state.Last.ErrType)
 			}
@@ -107,6 +150,8 @@ func StatKeeper(cfg *Config) {
 			if time.Since(lastCleanUpTime) > 30*time.Second {
 				storage.RemoveExpiredErrors(cfg.ExpireDurationDB, cfg)
 				storage.RemoveExpiredResults(cfg.ExpireDurationDB, cfg)
+				storage.RemoveExpiredEdges(cfg.ExpireDurationDB, cfg)
+				RemoveExpiredEdges(cfg.ExpireDurationDB)
 				lastCleanUpTime = time.Now()
 			}
 		}
@@ -131,6 +176,96 @@ func SaveResult(stream Stream, last Result) {
 	resultIn <- ResultInQuery{Stream: stream, Last: last}
 }
 
+// Keep the certificate chain of the host.
+func SaveCert(stream Stream, checked time.Time, meta MetaTLS) {
+	HostCerts.Lock()
+	HostCerts.data[meta.Host] = HostCert{Stream: stream, Checked: checked, TLS: meta}
+	HostCerts.Unlock()
+}
+
+// Get the last seen certificates of all hosts.
+func LoadCerts() []HostCert {
+	var certs []HostCert
+
+	HostCerts.RLock()
+	for _, cert := range HostCerts.data {
+		certs = append(certs, cert)
+	}
+	HostCerts.RUnlock()
+	return certs
+}
+
+// Keep the top level check of the stream for its edge.
+func SaveEdge(stream Stream, check KeepedEdge) {
+	key := edgeKey{stream.StreamKey, check.Edge}
+	EdgeStats.Lock()
+	edge, ok := EdgeStats.data[key]
+	if !ok {
+		edge = &edgeChecks{}
+		EdgeStats.data[key] = edge
+	}
+	edge.Stream = stream
+	edge.checks = append(edge.checks, edgeCheck{Started: check.Started, ErrType: check.ErrType})
+	EdgeStats.Unlock()
+}
+
+// Helper. Load edge checks of configured streams kept in Redis so edge reports survive restarts.
+func restoreEdges(cfg *Config) {
+	to := time.Now()
+	from := to.Add(-cfg.ExpireDurationDB)
+	for _, streams := range cfg.GroupStreams {
+		for key, stream := range streams {
+			checks, err := storage.RedLoadEdges(key, from, to)
+			if err != nil {
+				continue
+			}
+			for _, check := range checks {
+				SaveEdge(stream, check)
+			}
+		}
+	}
+}
+
+// Remove edge checks older than `expired`. Edges without checks left removed too.
+func RemoveExpiredEdges(expired time.Duration) {
+	since := time.Now().Add(-expired)
+	EdgeStats.Lock()
+	for key, edge := range EdgeStats.data {
+		i := 0
+		for i < len(edge.checks) && edge.checks[i].Started.Before(since) {
+			i++
+		}
+		if i == len(edge.checks) {
+			delete(EdgeStats.data, key)
+			continue
+		}
+		edge.checks = append(edge.checks[:0], edge.checks[i:]...)
+	}
+	EdgeStats.Unlock()
+}
+
+// Get checks of all edges summarized over the kept checks.
+func LoadEdges() []EdgeStat {
+	var edges []EdgeStat
+
+	EdgeStats.RLock()
+	for key, edge := range EdgeStats.data {
+		stat := EdgeStat{Stream: edge.Stream, Edge: key.Edge}
+		for _, check := range edge.checks {
+			stat.Checks++
+			stat.LastCheck = check.Started
+			if check.ErrType > WARNING_LEVEL {
+				stat.Errors++
+				stat.LastErr = check.ErrType
+				stat.LastError = check.Started
+			}
+		}
+		edges = append(edges, stat)
+	}
+	EdgeStats.RUnlock()
+	return edges
+}
+
 // Получить состояние по последней проверке.
 func LoadLastResult(key Key) (KeepedResult, error) {
 	result := make(chan []KeepedResult)
@@ -0,0 +1,47 @@
+package stats
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	. "github.com/hotid/streamsurfer/internal/pkg/structures"
+)
+
+func TestEdgeExpiry(t *testing.T) {
+	EdgeStats.data = make(map[edgeKey]*edgeChecks)
+	now := time.Now()
+	stream := Stream{StreamKey: sha256.Sum256([]byte("group/stream")), Name: "stream", Group: "group"}
+	checks := []struct {
+		edge string
+		ago  time.Duration
+		err  ErrType
+	}{
+		{"192.0.2.1", 3 * time.Hour, SUCCESS},
+		{"192.0.2.1", 2 * time.Hour, BADSTATUS},
+		{"192.0.2.1", time.Minute, SLOW},
+		{"192.0.2.2", 3 * time.Hour, REFUSED},
+		{"192.0.2.3", 2 * time.Hour, RTIMEOUT},
+		{"192.0.2.3", time.Minute, SUCCESS},
+	}
+	for _, check := range checks {
+		SaveEdge(stream, KeepedEdge{Edge: check.edge, Started: now.Add(-check.ago), ErrType: check.err})
+	}
+	RemoveExpiredEdges(150 * time.Minute)
+
+	expected := map[string]EdgeStat{
+		"192.0.2.1": {Checks: 2, Errors: 2, LastErr: SLOW, LastCheck: now.Add(-time.Minute), LastError: now.Add(-time.Minute)}, // warnings counted too
+		"192.0.2.3": {Checks: 2, Errors: 1, LastErr: RTIMEOUT, LastCheck: now.Add(-time.Minute), LastError: now.Add(-2 * time.Hour)},
+	}
+	edges := LoadEdges()
+	if len(edges) != len(expected) {
+		t.Fatalf("got %d edges, expected %d", len(edges), len(expected))
+	}
+	for _, edge := range edges {
+		want, ok := expected[edge.Edge]
+		want.Stream, want.Edge = stream, edge.Edge
+		if !ok || edge != want {
+			t.Errorf("edge %s: got %+v", edge.Edge, edge)
+		}
+	}
+}
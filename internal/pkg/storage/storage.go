@@ -55,6 +55,9 @@ func RedKeepResult(key Key, weight time.Time, res Result) error {
 			Group: res.Task.Group,
 			Title: res.Task.Title,
 		},
+		Kind:              res.Task.Kind,
+		Route:             res.Route,
+		FamilyFail:        res.FamilyFail,
 		ErrType:           res.ErrType,
 		HTTPCode:          res.HTTPCode,
 		HTTPStatus:        res.HTTPStatus,
@@ -64,7 +67,19 @@ func RedKeepResult(key Key, weight time.Time, res Result) error {
 		Body:              res.Body.Bytes(),
 		Started:           res.Started,
 		Elapsed:           res.Elapsed,
+		Throughput:        res.Throughput,
+		Timing:            res.Timing,
+		TLS:               res.TLS,
 		TotalErrs:         res.TotalErrs,
+		HLS:               res.HLS,
+		HDS:               res.HDS,
+		DASH:              res.DASH,
+		MSS:               res.MSS,
+		MP4:               res.MP4,
+		ICY:               res.ICY,
+		RTSP:              res.RTSP,
+		RTMP:              res.RTMP,
+		Media:             res.Media,
 	}
 	if res.Pid == nil {
 		keepit.Master = true
@@ -88,17 +103,28 @@ func RedKeepResult(key Key, weight time.Time, res Result) error {
 func RedKeepError(key Key, weight time.Time, errtype ErrType) error {
 	conn := redisPool.Get()
 	defer conn.Close()
-	_, err := conn.Do("ZADD", fmt.Sprintf("errors/%s", key.String()), strconv.FormatInt(weight.Unix(), 10), errtype)
+	_, err := conn.Do("ZADD", fmt.Sprintf("errors/%s", key.String()), strconv.FormatInt(weight.Unix(), 10), errtype.Code())
 	if err != nil {
 		fmt.Printf("redis RedKeepError: %s\n", err)
 	}
 	return err
 }
 
+// Keeps top level checks of the stream through edge addresses. The member is unique
+// for each check so checks with the same result not merged.
+func RedKeepEdge(key Key, check KeepedEdge) error {
+	conn := redisPool.Get()
+	defer conn.Close()
+	_, err := conn.Do("ZADD", fmt.Sprintf("edges/%s", key.String()), strconv.FormatInt(check.Started.Unix(), 10), fmt.Sprintf("%d %s %d", check.Started.UnixNano(), check.Edge, check.ErrType.Code()))
+	if err != nil {
+		fmt.Printf("redis RedKeepEdge: %s\n", err)
+	}
+	return err
+}
+
 func RedLoadResults(key Key, from, to time.Time) ([]KeepedResult, error) {
 	fmt.Println("RedLoadResults")
 	var src bytes.Buffer
-	var dst KeepedResult = KeepedResult{Stream: Stream{}, Headers: make(http.Header)}
 	var result []KeepedResult
 
 	conn := redisPool.Get()
@@ -106,6 +132,8 @@ func RedLoadResults(key Key, from, to time.Time) ([]KeepedResult, error) {
 	data, err := redis.Values(conn.Do("ZRANGEBYSCORE", key.String(), strconv.FormatInt(from.Unix(), 10), strconv.FormatInt(to.Unix(), 10)))
 	// dec := gob.NewDecoder(&src)
 	for _, val := range data {
+		// new value for each result because pointers and maps are reused by the decoder
+		var dst KeepedResult = KeepedResult{Stream: Stream{}, Headers: make(http.Header)}
 		src.Write(val.([]byte))
 		//err := dec.Decode(&dst)
 		err := json.Unmarshal(src.Bytes(), &dst)
@@ -128,7 +156,8 @@ func RedLoadErrors(key Key, from, to time.Time) (map[time.Time]ErrType, error) {
 	if err == nil {
 		for idx, val := range data {
 			if idx%2 == 0 { // data
-				retval = ErrType(val.([]uint8)[0])
+				code, _ := strconv.ParseUint(string(val.([]byte)), 10, 64)
+				retval = ErrTypeByCode(uint(code))
 			} else { // key
 				key, err := strconv.ParseInt(string(val.([]byte)), 10, 64)
 				if err == nil {
@@ -142,6 +171,29 @@ func RedLoadErrors(key Key, from, to time.Time) (map[time.Time]ErrType, error) {
 	}
 }
 
+// Load edge checks of the stream ordered by time.
+func RedLoadEdges(key Key, from, to time.Time) ([]KeepedEdge, error) {
+	var result []KeepedEdge
+
+	conn := redisPool.Get()
+	defer conn.Close()
+	data, err := redis.Strings(conn.Do("ZRANGEBYSCORE", fmt.Sprintf("edges/%s", key.String()), strconv.FormatInt(from.Unix(), 10), strconv.FormatInt(to.Unix(), 10)))
+	if err != nil {
+		return nil, err
+	}
+	for _, val := range data {
+		var started int64
+		var code uint
+		var check KeepedEdge
+		if _, err := fmt.Sscanf(val, "%d %s %d", &started, &check.Edge, &code); err == nil {
+			check.Started = time.Unix(0, started)
+			check.ErrType = ErrTypeByCode(code)
+			result = append(result, check)
+		}
+	}
+	return result, nil
+}
+
 // Remove expired errors from the Redis sorted set
 func RemoveExpiredErrors(expired time.Duration, config *Config) {
 	fmt.Println("RemoveExpiredErrors")
@@ -169,3 +221,17 @@ func RemoveExpiredResults(expired time.Duration, config *Config) {
 		}
 	}
 }
+
+// Remove expired edge checks from the Redis sorted set
+func RemoveExpiredEdges(expired time.Duration, config *Config) {
+	fmt.Println("RemoveExpiredEdges")
+	conn := redisPool.Get()
+	defer conn.Close()
+	for groupKey, _ := range config.GroupParams {
+		for streamKey, _ := range config.GroupStreams[groupKey] {
+			if deleted, _ := redis.Int(conn.Do("ZREMRANGEBYSCORE", fmt.Sprintf("edges/%s", streamKey.String()), "-inf", strconv.FormatInt(time.Now().Add(-expired).Unix(), 10))); deleted > 0 {
+				fmt.Printf("%d expired elements from `edges` set `%s` deleted\n", deleted, streamKey)
+			}
+		}
+	}
+}
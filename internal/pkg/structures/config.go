@@ -2,6 +2,9 @@ package structures
 
 import (
 	"crypto/sha256"
+	"net"
+	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -51,10 +54,13 @@ type Params struct {
 	RWTimeout              time.Duration `yaml:"rw-timeout,omitempty"`                // sec
 	SlowWarningTimeout     time.Duration `yaml:"slow-warning-timeout,omitempty"`      // sec
 	VerySlowWarningTimeout time.Duration `yaml:"very-slow-warning-timeout,omitempty"` // sec
+	SlowPhase              string        `yaml:"slow-phase,omitempty"`                // total, dns, connect, tls, ttfb, transfer
+	CertWarning            int           `yaml:"cert-warning,omitempty"`              // days
 	TimeBetweenTasks       time.Duration `yaml:"time-between-tasks,omitempty"`        // sec
 	TaskTTL                time.Duration `yaml:"task-ttl,omitempty"`                  // sec
 	TryOneSegment          bool          `yaml:"one-segment,omitempty"`
 	MethodHTTP             string        `yaml:"http-method,omitempty"` // GET, HEAD
+	LimitHTTP              int64         `yaml:"http-limit,omitempty"`  // bytes
 	ListenHTTP             string        `yaml:"http-api-listen,omitempty"`
 	ErrorLog               string        `yaml:"error-log,omitempty"`
 	Zabbix                 Zabbix        `yaml:"zabbix,omitempty"`
@@ -69,6 +75,19 @@ type Zabbix struct {
 	StreamTemplate  string   `yaml:"stream-template,omitempty"`
 }
 
+// Token signing of request URLs (secure links).
+type ConfigSign struct {
+	Scheme       string        `yaml:"scheme,omitempty"` // md5, hmac-sha1, hmac-sha256 or registered by monitor.RegisterSigner
+	Secret       string        `yaml:"secret,omitempty"`
+	TTL          time.Duration `yaml:"ttl,omitempty"`           // sec
+	TokenParam   string        `yaml:"token-param,omitempty"`   // query parameter of the token
+	ExpiresParam string        `yaml:"expires-param,omitempty"` // query parameter of the expiry time
+	Apply        []string      `yaml:"apply,omitempty"`         // master, variant, segment
+}
+
+// Days before TLS certificate expiry to warn when `cert-warning` not set for the group.
+const DefaultCertWarning = 14
+
 // parsed grup config
 type ConfigGroup struct {
 	Name                   string
@@ -80,10 +99,26 @@ type ConfigGroup struct {
 	RWTimeout              time.Duration
 	SlowWarningTimeout     time.Duration
 	VerySlowWarningTimeout time.Duration
+	SlowPhase              string // phase of HTTP request compared with slow thresholds (total elapsed time when empty)
+	CertWarning            int    // warn about TLS certificate expiry the number of days before (14 when not set)
 	TimeBetweenTasks       time.Duration
 	TaskTTL                time.Duration
 	TryOneSegment          bool
-	MethodHTTP             string
+	StaleFactor            float64 // live playlist is stale when not advanced for StaleFactor*TargetDuration
+	LatencyWarning         time.Duration
+	LatencyError           time.Duration
+	LowLatency             bool              // probe LL-HLS extensions of media playlists
+	MethodHTTP             string            // GET or HEAD for plain HTTP and Widevine checks
+	LimitHTTP              int64             // read no more than the number of bytes of the body for GET (partial GET)
+	EdgeProbe              bool              // probe addresses of the stream host in turn with the original Host and SNI
+	IPFamily               string            // v4, v6 or both (checks over each family in turn), any family when empty
+	Proxy                  *url.URL          // HTTP, HTTPS or SOCKS5 proxy for checks of all protocols (direct connections when nil)
+	SourceAddr             net.IP            // local address of outgoing connections (any when nil)
+	RouteTag               string            // name of the upstream path for results (proxy or source address by default)
+	ConnPool               string            // cold, warm or both (checks in each mode in turn), cold when empty
+	Headers                map[string]string // templates of request headers by name with $group, $stream, $host, $path etc.
+	Jar                    http.CookieJar    // cookies shared by requests of the group (nil when disabled)
+	Sign                   ConfigSign        // URL signing of requests (unsigned when scheme empty)
 	ParseMethod            string
 	User                   string
 	Pass                   string
@@ -92,4 +127,9 @@ type ConfigGroup struct {
 type HTTPConfig struct {
 	ConnectTimeout   time.Duration
 	ReadWriteTimeout time.Duration
+	Network          string   // tcp4 or tcp6 to force address family (as requested by default)
+	Proxy            *url.URL // proxy for HTTP requests (direct connections when nil)
+	LocalAddr        net.IP   // source address of connections (any when nil)
+	KeepAlive        bool     // keep connections for reuse (timeouts set per request instead of per connection)
+	InsecureTLS      bool     // skip verification of server certificates (to get the certificate which failed it)
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/grafov/m3u8"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -23,6 +24,12 @@ const (
 	HLS                         // Apple HTTP Live Streaming
 	HDS                         // Adobe HTTP Dynamic Streaming
 	WV                          // Widevine VOD
+	DASH                        // MPEG Dynamic Adaptive Streaming over HTTP
+	MSS                         // Microsoft Smooth Streaming
+	MP4                         // progressive MP4 VOD
+	ICY                         // Icecast/SHOUTcast internet radio
+	RTSP                        // Real Time Streaming Protocol
+	RTMP                        // Real Time Messaging Protocol
 )
 
 const (
@@ -34,7 +41,7 @@ const (
 
 // Error codes (ordered by errors importance).
 // If several errors detected then only one with the heaviest weight reported.
-// Must be in consistence with String2StreamErr() and StreamErr2String()
+// Must be in consistence with String2StreamErr(), StreamErr2String() and errCodes
 const (
 	SUCCESS        ErrType = iota
 	DEBUG_LEVEL            // Internal debug messages follow below:
@@ -44,20 +51,151 @@ const (
 	WARNING_LEVEL          // Warnings follow below:
 	SLOW                   // SlowWarning threshold on reading server response
 	VERYSLOW               // VerySlowWarning threshold on reading server response
+	LATENCY                // HLS specific: live latency exceeds LatencyWarning threshold
+	DURMISMATCH            // Real duration of the media segment differs from declared one
+	NOFASTSTART            // MP4 specific: moov placed after mdat so playback can't start before the whole file downloaded
+	HEADLENGTH             // HEAD response without Content-Length
+	CERTEXPIRES            // TLS certificate of the host expires soon
 	ERROR_LEVEL            // Errors follow below:
 	CTIMEOUT               // Timeout on connect
 	RTIMEOUT               // Timeout on read
+	CONNRESET              // Connection reset or closed by the server before the response completed
 	BADLENGTH              // ContentLength value not equal real content length
 	BODYREAD               // Response body read error
+	BADRANGE               // Widevine specific: range request not served with 206 and matching Content-Range or Accept-Ranges
+	NOSEQUENCE             // HLS specific: live media playlist without EXT-X-MEDIA-SEQUENCE
+	BADDURATION            // HLS specific: EXTINF duration exceeds EXT-X-TARGETDURATION
+	TSDISCONT              // MPEG-TS continuity counter errors or PCR/PTS discontinuities in the segment
+	BADDECODETIME          // fMP4 base media decode time not continuous between segments
+	VERYLATENCY            // HLS specific: live latency exceeds LatencyError threshold
+	BADPART                // LL-HLS specific: partial segments violate PART-TARGET or declared without EXT-X-PART-INF
+	BADRELOAD              // LL-HLS specific: blocking playlist reload not returned requested part in time
+	BADDYNAMIC             // DASH specific: dynamic MPD without availabilityStartTime or not available yet
+	LOWBITRATE             // ICY specific: audio data flows slower than advertised bitrate
 	CRITICAL_LEVEL         // Permanent errors level
 	REFUSED                // Connection refused
+	DNSFAIL                // Host name can't be resolved
+	TLSFAIL                // TLS handshake failed
+	BADCERT                // TLS certificate expired, not trusted or issued for another host
+	REDIRECTLOOP           // Too many redirects or redirect to already visited URL
 	BADSTATUS              // HTTP Status >= 400
 	BADURI                 // Incorret URI format
 	LISTEMPTY              // HLS specific (by m3u8 lib)
 	BADFORMAT              // HLS specific (by m3u8 lib)
+	BADSEGURI              // HLS specific: malformed URI of media segment
+	BADGROUP               // HLS specific: rendition group referenced by variant not declared in master playlist
+	STALEPLAYLIST          // HLS specific: live media playlist not advanced for a long time
+	BADTS                  // MPEG-TS structure broken (sync byte lost, no PAT/PMT)
+	BADBOX                 // fMP4 box structure truncated or malformed
+	BADKEY                 // HLS specific: encryption key or IV has wrong format
+	BADDECRYPT             // HLS specific: segment can't be decrypted with the key
+	BADMANIFEST            // HDS specific: F4M manifest can't be parsed or has no media
+	BADBOOTSTRAP           // HDS specific: bootstrap info can't be retrieved or decoded
+	BADFRAGMENT            // HDS specific: F4F fragment structure broken
+	BADMPD                 // DASH specific: MPD can't be parsed or segments of representation can't be addressed
+	STALEMPD               // DASH specific: timeline of dynamic MPD not advanced for a long time
+	BADSMOOTH              // MSS specific: Smooth Streaming manifest can't be parsed or fragments can't be addressed
+	NODATA                 // ICY specific: connected but no audio data received
+	BADSDP                 // RTSP specific: SDP of DESCRIBE can't be parsed or has no media
+	BADRTMP                // RTMP specific: handshake failed or connect/createStream rejected
 	UNKERR                 // хрень какая-то
 )
 
+// Stable numeric codes of errors kept in Redis and exported to Zabbix (/mon/error/{group}/{stream}/int).
+// ErrType values are ordered by severity and shift when new errors inserted so they never stored as is.
+// Codes of errors known before the reordering are equal to their former values thus the history kept by
+// older versions decoded unchanged. New errors get the next free code. Codes never reused.
+var errCodes = map[ErrType]uint{
+	SUCCESS:        0,
+	DEBUG_LEVEL:    1,
+	TTLEXPIRED:     2,
+	HLSPARSER:      3,
+	BADREQUEST:     4,
+	WARNING_LEVEL:  5,
+	SLOW:           6,
+	VERYSLOW:       7,
+	ERROR_LEVEL:    8,
+	CTIMEOUT:       9,
+	RTIMEOUT:       10,
+	BADLENGTH:      11,
+	BODYREAD:       12,
+	CRITICAL_LEVEL: 13,
+	REFUSED:        14,
+	BADSTATUS:      15,
+	BADURI:         16,
+	LISTEMPTY:      17,
+	BADFORMAT:      18,
+	UNKERR:         19,
+	NOSEQUENCE:     20,
+	BADDURATION:    21,
+	BADSEGURI:      22,
+	STALEPLAYLIST:  23,
+	DURMISMATCH:    24,
+	TSDISCONT:      25,
+	BADTS:          26,
+	BADDECODETIME:  27,
+	BADBOX:         28,
+	BADKEY:         29,
+	BADDECRYPT:     30,
+	BADGROUP:       31,
+	LATENCY:        32,
+	VERYLATENCY:    33,
+	BADPART:        34,
+	BADRELOAD:      35,
+	BADMANIFEST:    36,
+	BADBOOTSTRAP:   37,
+	BADFRAGMENT:    38,
+	BADDYNAMIC:     39,
+	BADMPD:         40,
+	STALEMPD:       41,
+	BADSMOOTH:      42,
+	BADRANGE:       43,
+	NOFASTSTART:    44,
+	LOWBITRATE:     45,
+	NODATA:         46,
+	BADSDP:         47,
+	BADRTMP:        48,
+	HEADLENGTH:     49,
+	CONNRESET:      50,
+	DNSFAIL:        51,
+	TLSFAIL:        52,
+	BADCERT:        53,
+	REDIRECTLOOP:   54,
+	CERTEXPIRES:    55,
+}
+
+// Stable code of the error for storage and monitoring systems.
+func (e ErrType) Code() uint {
+	if code, ok := errCodes[e]; ok {
+		return code
+	}
+	return errCodes[UNKERR]
+}
+
+// Error by its stable code (UNKERR for unknown codes).
+func ErrTypeByCode(code uint) ErrType {
+	for errtype, val := range errCodes {
+		if val == code {
+			return errtype
+		}
+	}
+	return UNKERR
+}
+
+// Results serialized with stable codes of errors.
+func (e ErrType) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatUint(uint64(e.Code()), 10)), nil
+}
+
+func (e *ErrType) UnmarshalJSON(data []byte) error {
+	code, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return err
+	}
+	*e = ErrTypeByCode(uint(code))
+	return nil
+}
+
 // Commands for probers.
 const (
 	STOP_MON Command = iota
@@ -88,21 +226,43 @@ type Stream struct {
 	Group     string
 }
 
+// Network path of the check.
+type Route struct {
+	Edge   string `json:",omitempty"` // IP address connected instead of resolving the host of the stream
+	Family string `json:",omitempty"` // address family of connections (v4 or v6), any when empty
+	Tag    string `json:",omitempty"` // name of the upstream path (proxy or source address)
+	Pool   string `json:",omitempty"` // connection mode: cold (new connection) or warm (keep-alive across checks)
+}
+
 // Stream checking task
 type Task struct {
 	Stream
-	ReadBody bool
-	ReplyTo  chan *Result
-	TTL      time.Time // valid until the time
-	Tid      int64     // task id (unique for each stream box)
+	ReadBody   bool
+	ReplyTo    chan *Result
+	TTL        time.Time                // valid until the time
+	Tid        int64                    // task id (unique for each stream box)
+	Kind       string                   // kind of the check for reports (media, segment etc.), empty for top level checks
+	Auth       bool                     // send credentials of the group with the request
+	Range      string                   // value of Range header for partial requests (bytes=first-last)
+	Limit      int64                    // read no more than the number of bytes of the body (0 for the whole body)
+	HTTPMethod string                   // method of HTTP request (GET when empty)
+	Route      Route                    // network path of the request (inherited by nested checks)
+	Playlists  map[string]PlaylistState // known state of live media playlists (DASH representations) by URI (read only for probers)
 }
 
 type VariantTask struct {
 	Task
 }
 
+// Media segment checking task
 type ChunkTask struct {
 	Task
+	SeqId    uint64    // media sequence number of the segment
+	Duration float64   // declared duration of the segment (sec)
+	Init     *MetaInit // parsed initialization segment for fMP4
+	Method   string    // encryption method from EXT-X-KEY (AES-128, SAMPLE-AES) or empty
+	Key      []byte    // encryption key, nil if not retrieved
+	IV       []byte
 }
 
 // Stream group
@@ -125,17 +285,34 @@ type Result struct {
 	Body              bytes.Buffer
 	Started           time.Time     // начало исполнения проверки
 	Elapsed           time.Duration // понадобилось времени на задачу
+	Throughput        int64         // bytes per second (for media segments)
+	Timing            *MetaTiming   // phases of HTTP request (nil for other protocols)
+	Route             Route         // network path of the check (copied from the task as the task reused by next checks)
+	FamilyFail        string        // address family (v4 or v6) the check failed over while another family works
+	TLS               *MetaTLS      // certificate chain of HTTPS connection (nil for plain connections)
 	TotalErrs         uint
 	//Meta              interface{} // Reference to metainformation about result data (playlist type etc.)
-	Pid        *Result   // link to parent check (is nil for top level URLs)
-	SubResults []*Result // Результаты вложенных проверок (i.e. media playlists for different bitrate of master playlists)
+	HLS        *MetaHLS   // properties of parsed HLS playlist (nil for other checks)
+	HDS        *MetaHDS   // properties of parsed HDS manifest or bootstrap info (nil for other checks)
+	DASH       *MetaDASH  // properties of parsed MPD (nil for other checks)
+	MSS        *MetaMSS   // properties of parsed Smooth Streaming manifest (nil for other checks)
+	MP4        *MetaMP4   // top level boxes and tracks of progressive MP4 file (nil for other checks)
+	ICY        *MetaICY   // properties of internet radio stream (nil for other checks)
+	RTSP       *MetaRTSP  // server methods and SDP media of RTSP stream (nil for other checks)
+	RTMP       *MetaRTMP  // connect and createStream results of RTMP stream (nil for other checks)
+	Media      *MetaMedia // results of media segment analysis (nil for other checks)
+	Pid        *Result    // link to parent check (is nil for top level URLs)
+	SubResults []*Result  // Результаты вложенных проверок (i.e. media playlists for different bitrate of master playlists)
 }
 
 // Results persistently keeped in Redis
 type KeepedResult struct {
 	Tid int64 // all subresults have same task id
 	Stream
-	Master            bool // is master result?
+	Master            bool   // is master result?
+	Kind              string // kind of the check (see Task)
+	Route             Route  // network path of the check
+	FamilyFail        string `json:",omitempty"` // address family the check failed over while another family works
 	ErrType           ErrType
 	HTTPCode          int    // HTTP status code
 	HTTPStatus        string // HTTP status string
@@ -145,7 +322,19 @@ type KeepedResult struct {
 	Body              []byte
 	Started           time.Time     // начало исполнения проверки
 	Elapsed           time.Duration // понадобилось времени на задачу
+	Throughput        int64         // bytes per second (for media segments)
+	Timing            *MetaTiming   `json:",omitempty"`
+	TLS               *MetaTLS      `json:",omitempty"`
 	TotalErrs         uint
+	HLS               *MetaHLS   `json:",omitempty"`
+	HDS               *MetaHDS   `json:",omitempty"`
+	DASH              *MetaDASH  `json:",omitempty"`
+	MSS               *MetaMSS   `json:",omitempty"`
+	MP4               *MetaMP4   `json:",omitempty"`
+	ICY               *MetaICY   `json:",omitempty"`
+	RTSP              *MetaRTSP  `json:",omitempty"`
+	RTMP              *MetaRTMP  `json:",omitempty"`
+	Media             *MetaMedia `json:",omitempty"`
 }
 
 // StreamBox statistics
@@ -156,18 +345,208 @@ type Stats struct {
 	Errors6hours int       //
 	LastCheck    time.Time // last check was at the time
 	NextCheck    time.Time // next check will be at this time
+	FamilyFail   string    // address family (v4 or v6) the last check failed over while another family works
 	// TODO результаты анализа потока в streambox (анализ перезапускать регулярно по таймеру)
 	// TODO вынести инфу о потоке потом в отдельную структуру
 }
 
 type MetaHLS struct {
-	ListType  m3u8.ListType // type of analyzed playlist
-	DeepLinks []string      // sublists for analysis
+	ListType       m3u8.ListType // type of analyzed playlist
+	DeepLinks      []string      // sublists for analysis
+	Live           bool          // sliding media playlist
+	TargetDuration float64       // declared EXT-X-TARGETDURATION
+	SeqNo          uint64        // EXT-X-MEDIA-SEQUENCE
+	LastSeqId      uint64        // media sequence number of the newest segment
+	LastURI        string        // URI of the newest segment
+	Rendition      string        // GROUP-ID and NAME of EXT-X-MEDIA rendition
+	Latency        time.Duration // live edge behind wall clock by EXT-X-PROGRAM-DATE-TIME (0 if unknown)
+	PartTarget     float64       // LL-HLS: PART-TARGET of EXT-X-PART-INF
+	Parts          int           // LL-HLS: number of EXT-X-PART in the playlist
+	CanBlockReload bool          // LL-HLS: CAN-BLOCK-RELOAD of EXT-X-SERVER-CONTROL
+	PreloadHint    string        // LL-HLS: URI of EXT-X-PRELOAD-HINT
+	MissingGroups  []string      // rendition groups referenced by variants but not declared
+}
+
+// Results of media segment analysis.
+type MetaMedia struct {
+	Container   string   // ts, fmp4
+	Streams     []string // elementary streams (tracks) found in the segment
+	Duration    float64  // real duration by timestamps (sec)
+	Declared    float64  // duration declared in the playlist (sec)
+	DecodeTime  float64  // fMP4 only: base media decode time of the segment (sec), -1 if unknown
+	SyncErrors  int      // MPEG-TS packets without sync byte
+	CCErrors    int      // MPEG-TS continuity counter errors
+	PCRJumps    int      // PCR discontinuities
+	PTSJumps    int      // PTS discontinuities
+	Broken      bool     // container structure broken
+	BadDuration bool     // real duration differs from declared
+	Problems    []string // human readable findings
+}
+
+// Properties of fragmented MP4 initialization segment.
+type MetaInit struct {
+	Brands []string // major and compatible brands from ftyp
+	Tracks []MetaTrack
+}
+
+// Track properties from moov.
+type MetaTrack struct {
+	Id              uint32
+	Handler         string // vide, soun, text etc.
+	Codec           string // type of the sample entry (avc1, mp4a etc.)
+	Timescale       uint32
+	Duration        uint64 // in timescale units
+	DefaultDuration uint32 // default sample duration for fragments (from trex)
+}
+
+// Last known state of the live media playlist. StreamBox keeps it between tasks.
+type PlaylistState struct {
+	SeqNo     uint64
+	LastSeqId uint64 // media sequence number of the newest segment
+	LastURI   string
+	Changed   time.Time // when the playlist was advanced last time
 }
 
 type MetaHDS struct {
-	ListType  m3u8.ListType // XXX type of analyzed playlist
-	DeepLinks []string      // sublists for analysis
+	Live         bool     // streamType of the manifest is live
+	DeepLinks    []string // media URLs of the manifest
+	MediaTime    float64  // current media time of the bootstrap info (sec)
+	LastSegment  uint32   // segment of the newest fragment
+	LastFragment uint32   // the newest fragment by the bootstrap info
+}
+
+// Properties of parsed MPD.
+type MetaDASH struct {
+	Dynamic           bool
+	AvailabilityStart time.Time     // availabilityStartTime of dynamic MPD
+	MinUpdatePeriod   time.Duration // minimumUpdatePeriod of dynamic MPD
+	Periods           int
+	Representations   []MetaRepresentation
+}
+
+// The newest segment of the representation.
+type MetaRepresentation struct {
+	Key        string // unique key of the representation in MPD (period/adaptation set/representation ids)
+	Bandwidth  uint64
+	Codecs     string
+	LastNumber uint64  // $Number$ of the newest segment
+	LastTime   uint64  // $Time$ of the newest segment
+	LastURI    string  // URL of the newest segment
+	Duration   float64 // duration of the newest segment (sec)
+}
+
+// Properties of parsed Smooth Streaming manifest.
+type MetaMSS struct {
+	Live          bool
+	Duration      float64 // presentation duration (sec), 0 for live
+	QualityLevels []MetaQualityLevel
+}
+
+// The newest fragment of the quality level.
+type MetaQualityLevel struct {
+	Type     string // type of the stream index (video, audio, text)
+	Name     string // name of the stream index
+	Bitrate  uint64
+	FourCC   string
+	LastTime uint64  // start time of the newest fragment (in timescale units)
+	LastURI  string  // URL of the newest fragment
+	Duration float64 // duration of the newest fragment (sec)
+}
+
+// Layout of progressive MP4 file.
+type MetaMP4 struct {
+	Boxes     []MetaBox   // top level boxes
+	FastStart bool        // moov placed before mdat
+	Size      int64       // file size by top level boxes
+	Brands    []string    // major and compatible brands from ftyp
+	Tracks    []MetaTrack // tracks from moov
+}
+
+// Top level box of MP4 file.
+type MetaBox struct {
+	Type   string
+	Offset int64
+	Size   int64
+}
+
+// Properties of Icecast/SHOUTcast stream.
+type MetaICY struct {
+	Name        string  // icy-name
+	ContentType string  // audio format (audio/mpeg, audio/aacp etc.)
+	MetaInt     int     // icy-metaint, audio bytes between metadata blocks (0 if metadata not sent)
+	Bitrate     int     // advertised icy-br (kbit/s)
+	StreamTitle string  // StreamTitle from the last metadata block
+	AudioBytes  int64   // audio data received
+	Rate        float64 // measured bitrate of audio data (kbit/s)
+}
+
+// Properties of RTSP stream.
+type MetaRTSP struct {
+	Server  string   // Server header of OPTIONS response
+	Methods []string // Public header of OPTIONS response
+	Media   []string // media descriptions of SDP (type and encoding)
+}
+
+// Properties of RTMP stream.
+type MetaRTMP struct {
+	App         string  // application name from the URL
+	FMSVer      string  // server version from connect result
+	Code        string  // status code of connect (NetConnection.Connect.Success etc.)
+	Description string  // status description of connect or error
+	StreamId    float64 // stream id returned by createStream
+}
+
+// Phases of HTTP request traced by httptrace. Phases skipped on reused connections are zero.
+type MetaTiming struct {
+	DNS      time.Duration // DNS lookup
+	Connect  time.Duration // TCP connect
+	TLS      time.Duration // TLS handshake
+	TTFB     time.Duration // from the request written until the first byte of the response
+	Transfer time.Duration // reading of the response body
+	Reused   bool          // connection taken from keep-alive pool
+}
+
+// TLS connection properties and the peer certificate chain.
+type MetaTLS struct {
+	Host      string     // server name of the connection
+	Version   string     // negotiated TLS version
+	Verified  bool       // chain verified with system roots
+	HostMatch bool       // leaf certificate issued for the host
+	Expires   time.Time  // the earliest expiry of certificates in the chain
+	Chain     []MetaCert // leaf certificate first
+}
+
+// Peer certificate.
+type MetaCert struct {
+	Subject  string
+	Issuer   string
+	NotAfter time.Time
+	DNSNames []string // subject alternative names
+}
+
+// Top level check of the stream through the edge address persistently keeped in Redis.
+type KeepedEdge struct {
+	Edge    string
+	Started time.Time
+	ErrType ErrType
+}
+
+// Checks of the stream through the edge address for the last `db-expired` hours.
+type EdgeStat struct {
+	Stream
+	Edge      string
+	Checks    int64
+	Errors    int64     // checks failed with errors
+	LastErr   ErrType   // the last error on the edge
+	LastCheck time.Time // time of the last check
+	LastError time.Time // time of the last failed check
+}
+
+// The last seen certificate chain of the host.
+type HostCert struct {
+	Stream            // the last checked stream of the host
+	Checked time.Time // time of the check
+	TLS     MetaTLS
 }
 
 // ключ для статистики